@@ -1,36 +1,126 @@
 package addressBook
 
 import (
+	"maps"
+	"sync"
+	"sync/atomic"
+
 	"github.com/bits-and-blooms/bloom/v3"
 	"github.com/ethereum/go-ethereum/common"
+
+	"deblockTest/pkg"
 )
 
+// AddressBook maps watched addresses to their owners and labels. Lookups read an immutable snapshot and never
+// block, while updates build a new snapshot and swap it in (copy-on-write).
 type AddressBook struct {
-	bloom         *bloom.BloomFilter
-	addressToUser map[common.Address]string
-	config        *Config
+	config   *Config
+	snapshot atomic.Pointer[snapshot]
+	// mu serializes the updates.
+	mu sync.Mutex
+}
+
+type snapshot struct {
+	bloom   *bloom.BloomFilter
+	records map[common.Address]pkg.AddressRecord
+	// removed counts the addresses removed since the bloom filter was built, they are still in it.
+	removed int
+	// capacity is the number of addresses the bloom filter is sized for.
+	capacity uint
 }
 
 func NewFromConfig(cfg *Config) *AddressBook {
-	return &AddressBook{
-		config: cfg,
-		bloom:  bloom.NewWithEstimates(cfg.BloomExpected, cfg.BloomFalsePos),
+	ab := &AddressBook{config: cfg}
+	ab.snapshot.Store(&snapshot{
+		bloom:    bloom.NewWithEstimates(cfg.BloomExpected, cfg.BloomFalsePos),
+		records:  map[common.Address]pkg.AddressRecord{},
+		capacity: cfg.BloomExpected,
+	})
+	return ab
+}
+
+// SetAddresses replaces all the addresses of the book.
+func (ab *AddressBook) SetAddresses(addresses map[common.Address]pkg.AddressRecord) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	ab.snapshot.Store(ab.build(maps.Clone(addresses)))
+}
+
+// Add watches new addresses, or replaces their record. Each update copies the book: add addresses in batches.
+func (ab *AddressBook) Add(addresses map[common.Address]pkg.AddressRecord) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	current := ab.snapshot.Load()
+	records := maps.Clone(current.records)
+	maps.Copy(records, addresses)
+	if uint(len(records)) > current.capacity {
+		// Past its capacity, the false positive rate of the filter climbs quickly.
+		ab.snapshot.Store(ab.build(records))
+		return
+	}
+
+	filter := current.bloom.Copy()
+	for addr := range addresses {
+		filter.Add(addr.Bytes())
 	}
+	ab.snapshot.Store(&snapshot{bloom: filter, records: records, removed: current.removed, capacity: current.capacity})
 }
 
-func (ab *AddressBook) SetAddresses(addresses map[common.Address]string) {
-	ab.addressToUser = make(map[common.Address]string, len(addresses))
+// Remove stops watching addresses. Bloom filters cannot forget entries: the filter is rebuilt once
+// the removed addresses reach RebuildRatio of the book, until then they only cost a map lookup.
+func (ab *AddressBook) Remove(addresses ...common.Address) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	current := ab.snapshot.Load()
+	records := maps.Clone(current.records)
+	removed := current.removed
+	for _, addr := range addresses {
+		if _, ok := records[addr]; ok {
+			delete(records, addr)
+			removed++
+		}
+	}
+
+	if float64(removed) > ab.config.rebuildRatio()*float64(len(records)) {
+		ab.snapshot.Store(ab.build(records))
+		return
+	}
+	ab.snapshot.Store(&snapshot{bloom: current.bloom, records: records, removed: removed, capacity: current.capacity})
+}
+
+// Len returns the number of watched addresses.
+func (ab *AddressBook) Len() int {
+	return len(ab.snapshot.Load().records)
+}
+
+// build returns a snapshot of records, which it takes ownership of, with a fresh bloom filter.
+func (ab *AddressBook) build(records map[common.Address]pkg.AddressRecord) *snapshot {
+	capacity := ab.capacity(len(records))
+	filter := bloom.NewWithEstimates(capacity, ab.config.BloomFalsePos)
+	for addr := range records {
+		filter.Add(addr.Bytes())
+	}
+	return &snapshot{bloom: filter, records: records, capacity: capacity}
+}
 
-	for addr, userID := range addresses {
-		ab.bloom.Add(addr.Bytes())
-		ab.addressToUser[addr] = userID
+// capacity returns the size of the bloom filter of n addresses: BloomExpected, doubled until it holds
+// them, so that the rebuilds of a growing book are amortized over its additions.
+func (ab *AddressBook) capacity(n int) uint {
+	capacity := max(ab.config.BloomExpected, 1)
+	for capacity < uint(n) {
+		capacity *= 2
 	}
+	return capacity
 }
 
-func (ab *AddressBook) GetUserID(addr common.Address) (string, bool) {
-	if !ab.bloom.Test(addr.Bytes()) {
-		return "", false
+// Lookup returns the record of a watched address.
+func (ab *AddressBook) Lookup(addr common.Address) (pkg.AddressRecord, bool) {
+	s := ab.snapshot.Load()
+	if !s.bloom.Test(addr.Bytes()) {
+		return pkg.AddressRecord{}, false
 	}
-	userID, ok := ab.addressToUser[addr]
-	return userID, ok
+	record, ok := s.records[addr]
+	return record, ok
 }
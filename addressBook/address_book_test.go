@@ -1,8 +1,14 @@
 package addressBook
 
 import (
+	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"slices"
+	"sync"
 	"testing"
+
+	"deblockTest/pkg"
 )
 
 func TestAddressBook(t *testing.T) {
@@ -17,39 +23,129 @@ func TestAddressBook(t *testing.T) {
 	}
 
 	addr := common.HexToAddress("0x1234567890123456789012345678901234567890")
-	userID, found := ab.GetUserID(addr)
+	record, found := ab.Lookup(addr)
 	if found {
-		t.Errorf("Expected address not to be found in empty book, but got userID: %s", userID)
+		t.Errorf("Expected address not to be found in empty book, but got owners: %v", record.Owners)
 	}
 
-	addresses := map[common.Address]string{
-		common.HexToAddress("0x1234567890123456789012345678901234567890"): "user1",
-		common.HexToAddress("0x2345678901234567890123456789012345678901"): "user2",
-		common.HexToAddress("0x3456789012345678901234567890123456789012"): "user3",
+	addresses := map[common.Address]pkg.AddressRecord{
+		common.HexToAddress("0x1234567890123456789012345678901234567890"): pkg.Owner("user1"),
+		common.HexToAddress("0x2345678901234567890123456789012345678901"): pkg.Owner("user2"),
+		common.HexToAddress("0x3456789012345678901234567890123456789012"): {
+			Owners: []string{"user3", "user4"},
+			Labels: map[string]string{pkg.LabelWalletType: "omnibus"},
+		},
 	}
 	ab.SetAddresses(addresses)
 
 	testCases := []struct {
-		address    string
-		expectedID string
-		shouldFind bool
+		address        string
+		expectedOwners []string
+		shouldFind     bool
 	}{
-		{"0x1234567890123456789012345678901234567890", "user1", true},
-		{"0x2345678901234567890123456789012345678901", "user2", true},
-		{"0x3456789012345678901234567890123456789012", "user3", true},
-		{"0x9999999999999999999999999999999999999999", "", false},
+		{"0x1234567890123456789012345678901234567890", []string{"user1"}, true},
+		{"0x2345678901234567890123456789012345678901", []string{"user2"}, true},
+		{"0x3456789012345678901234567890123456789012", []string{"user3", "user4"}, true},
+		{"0x9999999999999999999999999999999999999999", nil, false},
 	}
 
 	for _, tc := range testCases {
 		addr := common.HexToAddress(tc.address)
-		userID, found := ab.GetUserID(addr)
+		record, found := ab.Lookup(addr)
 
 		if found != tc.shouldFind {
 			t.Errorf("For address %s: expected found=%v, got found=%v", tc.address, tc.shouldFind, found)
 		}
 
-		if found && userID != tc.expectedID {
-			t.Errorf("For address %s: expected userID=%s, got userID=%s", tc.address, tc.expectedID, userID)
+		if found && !slices.Equal(record.Owners, tc.expectedOwners) {
+			t.Errorf("For address %s: expected owners=%v, got owners=%v", tc.address, tc.expectedOwners, record.Owners)
 		}
 	}
+
+	shared, _ := ab.Lookup(common.HexToAddress("0x3456789012345678901234567890123456789012"))
+	if shared.Labels[pkg.LabelWalletType] != "omnibus" {
+		t.Errorf("Expected the labels of the shared address, got %v", shared.Labels)
+	}
+}
+
+func TestAddressBook_AddRemove(t *testing.T) {
+	ab := NewFromConfig(&Config{BloomExpected: 100, BloomFalsePos: 0.01, RebuildRatio: 0.5})
+	addresses := make(map[common.Address]pkg.AddressRecord)
+	for i := 1; i <= 10; i++ {
+		addresses[common.BigToAddress(big.NewInt(int64(i)))] = pkg.Owner(fmt.Sprintf("user%d", i))
+	}
+	ab.SetAddresses(addresses)
+
+	newcomer := common.HexToAddress("0xabc")
+	ab.Add(map[common.Address]pkg.AddressRecord{newcomer: pkg.Owner("user11")})
+	if record, found := ab.Lookup(newcomer); !found || record.Owners[0] != "user11" {
+		t.Errorf("Expected added address to belong to user11, got %v, %v", record.Owners, found)
+	}
+
+	first := common.BigToAddress(big.NewInt(1))
+	ab.Remove(first)
+	if _, found := ab.Lookup(first); found {
+		t.Error("Expected removed address not to be found")
+	}
+	if ab.snapshot.Load().removed != 1 || ab.Len() != 10 {
+		t.Errorf("Expected 10 addresses and 1 pending removal, got %d and %d", ab.Len(), ab.snapshot.Load().removed)
+	}
+
+	// Past the rebuild ratio, the bloom filter forgets the removed addresses.
+	for i := 2; i <= 4; i++ {
+		ab.Remove(common.BigToAddress(big.NewInt(int64(i))))
+	}
+	s := ab.snapshot.Load()
+	if s.removed != 0 || s.bloom.Test(first.Bytes()) {
+		t.Errorf("Expected the bloom filter to be rebuilt, %d removals pending", s.removed)
+	}
+
+	// Growing past BloomExpected resizes the filter.
+	capacity := s.bloom.Cap()
+	more := make(map[common.Address]pkg.AddressRecord)
+	for i := 1000; i < 1200; i++ {
+		more[common.BigToAddress(big.NewInt(int64(i)))] = pkg.Owner("bulk")
+	}
+	ab.Add(more)
+	if ab.Len() != 207 || ab.snapshot.Load().bloom.Cap() <= capacity {
+		t.Errorf("Expected 207 addresses in a larger filter, got %d", ab.Len())
+	}
+
+	// The filter doubles, the next additions fit in it without a rebuild.
+	grown := ab.snapshot.Load()
+	if grown.capacity != 400 {
+		t.Errorf("Expected a capacity of 400 addresses, got %d", grown.capacity)
+	}
+	for i := 2000; i < 2100; i++ {
+		ab.Add(map[common.Address]pkg.AddressRecord{common.BigToAddress(big.NewInt(int64(i))): pkg.Owner("one by one")})
+	}
+	if s := ab.snapshot.Load(); s.capacity != 400 || s.bloom.Cap() != grown.bloom.Cap() {
+		t.Errorf("Expected the filter to keep its size, got a capacity of %d", s.capacity)
+	}
+}
+
+func TestAddressBook_ConcurrentUpdates(t *testing.T) {
+	ab := NewFromConfig(&Config{BloomExpected: 1000, BloomFalsePos: 0.01})
+	watched := common.HexToAddress("0x1234")
+	ab.SetAddresses(map[common.Address]pkg.AddressRecord{watched: pkg.Owner("user1")})
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if _, found := ab.Lookup(watched); !found {
+					t.Error("Expected the untouched address to stay watched")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+		ab.Add(map[common.Address]pkg.AddressRecord{addr: pkg.Owner("user")})
+		ab.Remove(addr)
+	}
+	wg.Wait()
 }
@@ -3,4 +3,14 @@ package addressBook
 type Config struct {
 	BloomExpected uint
 	BloomFalsePos float64
+	// RebuildRatio is the share of removed addresses, still set in the bloom filter, which triggers
+	// its rebuild. Defaults to 0.1.
+	RebuildRatio float64
+}
+
+func (c *Config) rebuildRatio() float64 {
+	if c.RebuildRatio == 0 {
+		return 0.1
+	}
+	return c.RebuildRatio
 }
@@ -0,0 +1,207 @@
+package addressBook
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bits-and-blooms/bloom/v3"
+	"github.com/ethereum/go-ethereum/common"
+
+	"deblockTest/pkg"
+)
+
+// SnapshotVersion is the version of the snapshot format written by WriteSnapshot.
+//
+// A snapshot is the magic "ABSN" and the version (uint32), the fingerprint of the source it was
+// built from, the number of removed addresses still in the bloom filter (uint64), the bloom filter
+// (see bloom.BloomFilter.WriteTo), the number of addresses (uint64), then each address followed by
+// its owners and labels. Integers are big endian, strings and lists are prefixed by their uvarint length.
+const SnapshotVersion = 1
+
+var snapshotMagic = [4]byte{'A', 'B', 'S', 'N'}
+
+// ErrNotSnapshot is returned when reading a file which is not an address book snapshot.
+var ErrNotSnapshot = errors.New("not an address book snapshot")
+
+// WriteSnapshot writes the book, and the fingerprint of its source, to w.
+func (ab *AddressBook) WriteSnapshot(w io.Writer, fingerprint string) error {
+	s := ab.snapshot.Load()
+	bw := bufio.NewWriter(w)
+
+	bw.Write(snapshotMagic[:])
+	binary.Write(bw, binary.BigEndian, uint32(SnapshotVersion))
+	writeString(bw, fingerprint)
+	binary.Write(bw, binary.BigEndian, uint64(s.removed))
+	if _, err := s.bloom.WriteTo(bw); err != nil {
+		return err
+	}
+
+	binary.Write(bw, binary.BigEndian, uint64(len(s.records)))
+	for addr, record := range s.records {
+		bw.Write(addr.Bytes())
+		writeUvarint(bw, uint64(len(record.Owners)))
+		for _, owner := range record.Owners {
+			writeString(bw, owner)
+		}
+		writeUvarint(bw, uint64(len(record.Labels)))
+		for k, v := range record.Labels {
+			writeString(bw, k)
+			writeString(bw, v)
+		}
+	}
+	// bufio keeps the first write error, and returns it from Flush.
+	return bw.Flush()
+}
+
+// ReadSnapshot replaces the book with the snapshot read from r, and returns the fingerprint of its source.
+// The book is left untouched if the snapshot cannot be read.
+func (ab *AddressBook) ReadSnapshot(r io.Reader) (string, error) {
+	br := bufio.NewReader(r)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil || magic != snapshotMagic {
+		return "", ErrNotSnapshot
+	}
+	var version uint32
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return "", err
+	}
+	if version != SnapshotVersion {
+		return "", fmt.Errorf("unsupported snapshot version %d, expected %d", version, SnapshotVersion)
+	}
+
+	fingerprint, err := readString(br)
+	if err != nil {
+		return "", err
+	}
+	var removed, count uint64
+	if err := binary.Read(br, binary.BigEndian, &removed); err != nil {
+		return "", err
+	}
+	filter := &bloom.BloomFilter{}
+	if _, err := filter.ReadFrom(br); err != nil {
+		return "", fmt.Errorf("read bloom filter: %w", err)
+	}
+	if err := binary.Read(br, binary.BigEndian, &count); err != nil {
+		return "", err
+	}
+
+	records := make(map[common.Address]pkg.AddressRecord, min(count, 1<<24))
+	for i := uint64(0); i < count; i++ {
+		var addr common.Address
+		if _, err := io.ReadFull(br, addr[:]); err != nil {
+			return "", fmt.Errorf("read address %d: %w", i, err)
+		}
+		record, err := readRecord(br)
+		if err != nil {
+			return "", fmt.Errorf("read address %s: %w", addr.Hex(), err)
+		}
+		records[addr] = record
+	}
+
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	// The filter was built for at least the capacity of its addresses.
+	ab.snapshot.Store(&snapshot{bloom: filter, records: records, removed: int(removed), capacity: ab.capacity(len(records))})
+	return fingerprint, nil
+}
+
+// SaveSnapshot writes the book to path. The previous snapshot is replaced atomically.
+func (ab *AddressBook) SaveSnapshot(path, fingerprint string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := ab.WriteSnapshot(tmp, fingerprint); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot replaces the book with the snapshot at path, and returns the fingerprint of its source.
+func (ab *AddressBook) LoadSnapshot(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return ab.ReadSnapshot(f)
+}
+
+func readRecord(br *bufio.Reader) (pkg.AddressRecord, error) {
+	var record pkg.AddressRecord
+	owners, err := binary.ReadUvarint(br)
+	if err != nil {
+		return record, err
+	}
+	if owners > maxSnapshotItems {
+		return record, fmt.Errorf("%d owners is too many", owners)
+	}
+	record.Owners = make([]string, owners)
+	for i := range record.Owners {
+		if record.Owners[i], err = readString(br); err != nil {
+			return record, err
+		}
+	}
+
+	labels, err := binary.ReadUvarint(br)
+	if err != nil || labels == 0 {
+		return record, err
+	}
+	if labels > maxSnapshotItems {
+		return record, fmt.Errorf("%d labels is too many", labels)
+	}
+	record.Labels = make(map[string]string, labels)
+	for i := uint64(0); i < labels; i++ {
+		k, err := readString(br)
+		if err != nil {
+			return record, err
+		}
+		if record.Labels[k], err = readString(br); err != nil {
+			return record, err
+		}
+	}
+	return record, nil
+}
+
+// maxSnapshotString and maxSnapshotItems bound the strings, owners and labels of a snapshot, so that
+// a corrupted length fails instead of allocating.
+const (
+	maxSnapshotString = 1 << 16
+	maxSnapshotItems  = 1 << 16
+)
+
+func readString(br *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return "", err
+	}
+	if n > maxSnapshotString {
+		return "", fmt.Errorf("string of %d bytes is too long", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func writeString(bw *bufio.Writer, s string) {
+	writeUvarint(bw, uint64(len(s)))
+	bw.WriteString(s)
+}
+
+func writeUvarint(bw *bufio.Writer, n uint64) {
+	bw.Write(binary.AppendUvarint(nil, n))
+}
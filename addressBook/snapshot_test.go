@@ -0,0 +1,96 @@
+package addressBook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"deblockTest/pkg"
+)
+
+func TestAddressBook_Snapshot(t *testing.T) {
+	ab := NewFromConfig(&Config{BloomExpected: 100, BloomFalsePos: 0.01})
+	addresses := make(map[common.Address]pkg.AddressRecord)
+	for i := 1; i <= 50; i++ {
+		addresses[common.BigToAddress(big.NewInt(int64(i)))] = pkg.Owner("user")
+	}
+	shared := common.HexToAddress("0xabc")
+	addresses[shared] = pkg.AddressRecord{
+		Owners: []string{"user1", "user2"},
+		Labels: map[string]string{pkg.LabelWalletType: "omnibus", pkg.LabelRiskTier: "high"},
+	}
+	ab.SetAddresses(addresses)
+	removed := common.BigToAddress(big.NewInt(1))
+	ab.Remove(removed)
+
+	path := filepath.Join(t.TempDir(), "addresses.snap")
+	if err := ab.SaveSnapshot(path, "v1"); err != nil {
+		t.Fatalf("Failed to save snapshot: %v", err)
+	}
+
+	loaded := NewFromConfig(&Config{BloomExpected: 100, BloomFalsePos: 0.01})
+	fingerprint, err := loaded.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	if fingerprint != "v1" || loaded.Len() != 50 || loaded.snapshot.Load().removed != 1 {
+		t.Errorf("Unexpected snapshot: fingerprint %q, %d addresses", fingerprint, loaded.Len())
+	}
+	record, found := loaded.Lookup(shared)
+	if !found || !slices.Equal(record.Owners, []string{"user1", "user2"}) || record.Labels[pkg.LabelRiskTier] != "high" {
+		t.Errorf("Unexpected shared address: %+v, %v", record, found)
+	}
+	if _, found := loaded.Lookup(removed); found {
+		t.Error("Expected the removed address not to be found")
+	}
+	// The filter is restored as is, with the removed address still set.
+	if !loaded.snapshot.Load().bloom.Test(removed.Bytes()) {
+		t.Error("Expected the bloom filter to be restored")
+	}
+}
+
+func TestAddressBook_ReadSnapshotInvalid(t *testing.T) {
+	ab := NewFromConfig(&Config{BloomExpected: 100, BloomFalsePos: 0.01})
+	ab.SetAddresses(map[common.Address]pkg.AddressRecord{common.HexToAddress("0x1"): pkg.Owner("user1")})
+	var buf bytes.Buffer
+	if err := ab.WriteSnapshot(&buf, ""); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	if _, err := ab.ReadSnapshot(bytes.NewReader([]byte("address,user_id\n"))); !errors.Is(err, ErrNotSnapshot) {
+		t.Errorf("Expected ErrNotSnapshot, got %v", err)
+	}
+
+	future := slices.Clone(valid)
+	future[7] = SnapshotVersion + 1
+	if _, err := ab.ReadSnapshot(bytes.NewReader(future)); err == nil {
+		t.Error("Expected an error for an unknown version")
+	}
+
+	if _, err := ab.ReadSnapshot(bytes.NewReader(valid[:len(valid)-5])); err == nil {
+		t.Error("Expected an error for a truncated snapshot")
+	}
+
+	// The last record ends with its owner count (1), the owner and the label count (0).
+	for _, count := range []string{"owners", "labels"} {
+		corrupted := slices.Clone(valid[:len(valid)-len("user1")-3])
+		if count == "labels" {
+			corrupted = append(corrupted, 1, 5)
+			corrupted = append(corrupted, "user1"...)
+		}
+		corrupted = binary.AppendUvarint(corrupted, 1<<62)
+		if _, err := ab.ReadSnapshot(bytes.NewReader(corrupted)); err == nil {
+			t.Errorf("Expected an error for a corrupted number of %s", count)
+		}
+	}
+	if ab.Len() != 1 {
+		t.Errorf("Expected the book to be left untouched, got %d addresses", ab.Len())
+	}
+}
@@ -0,0 +1,131 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Client is an ethclient.Client that can also fetch several blocks in one JSON-RPC batch request.
+// Its blocks leave out the transactions of types go-ethereum cannot decode, such as the system
+// transactions starting every OP-stack (deposits, 0x7e) and Arbitrum (0x64-0x6a) block.
+type Client struct {
+	*ethclient.Client
+	rpc *rpc.Client
+}
+
+func NewClient(client *rpc.Client) *Client {
+	return &Client{Client: ethclient.NewClient(client), rpc: client}
+}
+
+type rpcBody struct {
+	Transactions []json.RawMessage   `json:"transactions"`
+	Withdrawals  []*types.Withdrawal `json:"withdrawals"`
+}
+
+// BlockByNumber returns a block, nil number being the latest one. Unlike ethclient, it tolerates
+// unknown transaction types, and leaves uncles out.
+func (c *Client) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	var raw json.RawMessage
+	if err := c.rpc.CallContext(ctx, &raw, "eth_getBlockByNumber", toBlockNumArg(number), true); err != nil {
+		return nil, err
+	}
+	return decodeBlock(raw)
+}
+
+func toBlockNumArg(number *big.Int) string {
+	if number == nil {
+		return "latest"
+	}
+	if number.Sign() >= 0 {
+		return hexutil.EncodeBig(number)
+	}
+	// Negative numbers are the block tags, e.g. rpc.SafeBlockNumber.
+	return rpc.BlockNumber(number.Int64()).String()
+}
+
+// BlocksByNumber fetches the given blocks with a single eth_getBlockByNumber batch.
+// errs[i] is set when blocks[i] could not be fetched; when the whole batch fails, every entry is set.
+// Uncles are left out of the returned blocks, they are not needed to detect transfers.
+func (c *Client) BlocksByNumber(ctx context.Context, numbers []uint64) (blocks []*types.Block, errs []error) {
+	raws := make([]json.RawMessage, len(numbers))
+	elems := make([]rpc.BatchElem, len(numbers))
+	for i, n := range numbers {
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []any{hexutil.EncodeUint64(n), true},
+			Result: &raws[i],
+		}
+	}
+
+	blocks, errs = make([]*types.Block, len(numbers)), make([]error, len(numbers))
+	if err := c.rpc.BatchCallContext(ctx, elems); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return blocks, errs
+	}
+	for i, elem := range elems {
+		if elem.Error != nil {
+			errs[i] = elem.Error
+			continue
+		}
+		blocks[i], errs[i] = decodeBlock(raws[i])
+		if errs[i] == nil && blocks[i].NumberU64() != numbers[i] {
+			blocks[i], errs[i] = nil, fmt.Errorf("got block %d instead of %d", blocks[i].NumberU64(), numbers[i])
+		}
+	}
+	return blocks, errs
+}
+
+func decodeBlock(raw json.RawMessage) (*types.Block, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+
+	var header types.Header
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, err
+	}
+	var body rpcBody
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, err
+	}
+
+	// Same sanity checks as ethclient, some nodes answer with a partial block.
+	if header.TxHash == types.EmptyTxsHash && len(body.Transactions) > 0 {
+		return nil, errors.New("server returned non-empty transaction list but block header indicates no transactions")
+	}
+	if header.TxHash != types.EmptyTxsHash && len(body.Transactions) == 0 {
+		return nil, errors.New("server returned empty transaction list but block header indicates transactions")
+	}
+	if header.WithdrawalsHash != nil && body.Withdrawals == nil {
+		body.Withdrawals = []*types.Withdrawal{}
+	}
+
+	txs := make([]*types.Transaction, 0, len(body.Transactions))
+	for i, raw := range body.Transactions {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalJSON(raw); err != nil {
+			if errors.Is(err, types.ErrTxTypeNotSupported) {
+				// L2 system transaction.
+				continue
+			}
+			return nil, fmt.Errorf("transaction %d: %w", i, err)
+		}
+		txs = append(txs, tx)
+	}
+
+	return types.NewBlockWithHeader(&header).WithBody(types.Body{
+		Transactions: txs,
+		Withdrawals:  body.Withdrawals,
+	}), nil
+}
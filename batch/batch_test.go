@@ -0,0 +1,138 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// fakeEth serves eth_getBlockByNumber from a set of blocks, as a node would encode them.
+type fakeEth struct {
+	blocks map[uint64]*types.Block
+	// system are transactions of types unknown to go-ethereum, served first in the block.
+	system []map[string]any
+}
+
+func (f fakeEth) GetBlockByNumber(number hexutil.Uint64, _ bool) (map[string]any, error) {
+	block, ok := f.blocks[uint64(number)]
+	if !ok {
+		return nil, nil
+	}
+	raw, err := json.Marshal(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	txs := make([]any, 0, len(f.system)+block.Transactions().Len())
+	for _, tx := range f.system {
+		txs = append(txs, tx)
+	}
+	for _, tx := range block.Transactions() {
+		txs = append(txs, tx)
+	}
+	fields["transactions"] = txs
+	fields["withdrawals"] = block.Withdrawals()
+	return fields, nil
+}
+
+func TestClient_BlocksByNumber(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	to := common.HexToAddress("0xaa")
+	blocks := map[uint64]*types.Block{}
+	for n := uint64(1); n <= 2; n++ {
+		tx, _ := types.SignTx(types.NewTx(&types.DynamicFeeTx{
+			ChainID: big.NewInt(1), Nonce: n, To: &to, Value: big.NewInt(int64(n)), Gas: 21000,
+			GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(1e9),
+		}), signer, key)
+		header := &types.Header{Number: new(big.Int).SetUint64(n), Difficulty: common.Big0, BaseFee: big.NewInt(1)}
+		body := &types.Body{
+			Transactions: []*types.Transaction{tx},
+			Withdrawals:  []*types.Withdrawal{{Index: n, Address: to, Amount: 32}},
+		}
+		blocks[n] = types.NewBlock(header, body, nil, trie.NewStackTrie(nil))
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", fakeEth{blocks: blocks}); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	got, errs := NewClient(client).BlocksByNumber(context.Background(), []uint64{1, 2, 3})
+	for n := uint64(1); n <= 2; n++ {
+		if errs[n-1] != nil {
+			t.Fatalf("block %d: %v", n, errs[n-1])
+		}
+		if got[n-1].Hash() != blocks[n].Hash() {
+			t.Errorf("block %d: expected hash %s, got %s", n, blocks[n].Hash(), got[n-1].Hash())
+		}
+		if got[n-1].Transactions()[0].Hash() != blocks[n].Transactions()[0].Hash() || len(got[n-1].Withdrawals()) != 1 {
+			t.Errorf("block %d: body not decoded", n)
+		}
+	}
+	if !errors.Is(errs[2], ethereum.NotFound) {
+		t.Errorf("expected block 3 not to be found, got %v", errs[2])
+	}
+}
+
+func TestClient_UnknownTransactionTypes(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := types.LatestSignerForChainID(big.NewInt(10))
+	to := common.HexToAddress("0xaa")
+	tx, _ := types.SignTx(types.NewTx(&types.DynamicFeeTx{
+		ChainID: big.NewInt(10), To: &to, Value: big.NewInt(1), Gas: 21000,
+		GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(1e9),
+	}), signer, key)
+	header := &types.Header{Number: big.NewInt(7), Difficulty: common.Big0, BaseFee: big.NewInt(1)}
+	block := types.NewBlock(header, &types.Body{Transactions: []*types.Transaction{tx}}, nil, trie.NewStackTrie(nil))
+
+	// Every OP-stack block starts with an L1 attributes deposit.
+	deposit := map[string]any{
+		"type":       "0x7e",
+		"hash":       common.HexToHash("0x01").Hex(),
+		"sourceHash": common.HexToHash("0x02").Hex(),
+		"from":       "0xdeaddeaddeaddeaddeaddeaddeaddeaddead0001",
+		"to":         "0x4200000000000000000000000000000000000015",
+		"mint":       "0x0",
+		"value":      "0x0",
+		"gas":        "0xf4240",
+		"input":      "0x",
+		"nonce":      "0x0",
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", fakeEth{blocks: map[uint64]*types.Block{7: block}, system: []map[string]any{deposit}}); err != nil {
+		t.Fatal(err)
+	}
+	rpcClient := rpc.DialInProc(server)
+	defer rpcClient.Close()
+	client := NewClient(rpcClient)
+
+	single, err := client.BlockByNumber(context.Background(), big.NewInt(7))
+	if err != nil {
+		t.Fatalf("BlockByNumber: %v", err)
+	}
+	batched, errs := client.BlocksByNumber(context.Background(), []uint64{7})
+	if errs[0] != nil {
+		t.Fatalf("BlocksByNumber: %v", errs[0])
+	}
+	for _, got := range []*types.Block{single, batched[0]} {
+		if got.Hash() != block.Hash() || got.Transactions().Len() != 1 || got.Transactions()[0].Hash() != tx.Hash() {
+			t.Errorf("expected the deposit to be skipped, got %d transactions", got.Transactions().Len())
+		}
+	}
+}
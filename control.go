@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"deblockTest/addressBook"
+	"deblockTest/ratelimit"
+	"deblockTest/registry"
+	service2 "deblockTest/service"
+)
+
+var errBackfillRunning = errors.New("backfill already running")
+
+// backfills runs the backfills started in the live process, on its limiter: they only get the
+// compute units the live pipelines leave.
+type backfills struct {
+	ctx     context.Context
+	chains  *registry.Config
+	ab      *addressBook.AddressBook
+	ready   service2.Readiness
+	limiter *ratelimit.Limiter
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// start re-indexes a range given as chain:from-to in the background.
+func (b *backfills) start(spec string) error {
+	c, from, to, err := parseBackfillRange(b.chains, spec)
+	if err != nil {
+		return err
+	}
+
+	// Two backfills of the same range would share their checkpoint.
+	key := fmt.Sprintf("%s:%d-%d", c.Name, from, to)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running[key] {
+		return fmt.Errorf("%s: %w", key, errBackfillRunning)
+	}
+	b.running[key] = true
+
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			delete(b.running, key)
+			b.mu.Unlock()
+		}()
+
+		log.Printf("%s: backfilling blocks %d to %d", c.Name, from, to)
+		ctx := ratelimit.WithPriority(b.ctx, ratelimit.Low)
+		if err := runBackfill(ctx, c, from, to, "", b.ab, b.ready, b.limiter); err != nil {
+			log.Printf("%s: backfill interrupted: %v", c.Name, err)
+			return
+		}
+		log.Printf("%s: backfill of blocks %d to %d done", c.Name, from, to)
+	}()
+	return nil
+}
+
+// ServeHTTP starts a backfill, e.g. POST /backfill?range=ethereum:19000000-19001000.
+func (b *backfills) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	err := b.start(r.URL.Query().Get("range"))
+	switch {
+	case errors.Is(err, errBackfillRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// parseBackfillRange reads a range to backfill, e.g. ethereum:19000000-19001000.
+func parseBackfillRange(chains *registry.Config, spec string) (*registry.Chain, uint64, uint64, error) {
+	name, blocks, _ := strings.Cut(spec, ":")
+	first, last, _ := strings.Cut(blocks, "-")
+	from, err1 := strconv.ParseUint(first, 10, 64)
+	to, err2 := strconv.ParseUint(last, 10, 64)
+	if err1 != nil || err2 != nil || from > to {
+		return nil, 0, 0, fmt.Errorf("invalid backfill range %q, expected chain:from-to", spec)
+	}
+	c, err := chainByName(chains, name)
+	return c, from, to, err
+}
+
+func chainByName(chains *registry.Config, name string) (*registry.Chain, error) {
+	for i := range chains.Chains {
+		if chains.Chains[i].Name == name {
+			return &chains.Chains[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown chain %s", name)
+}
+
+// serveControl serves the control endpoints of the live process until ctx is done.
+func serveControl(ctx context.Context, addr string, handler http.Handler) {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Control endpoint %s stopped: %v", addr, err)
+	}
+}
@@ -0,0 +1,268 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/segmentio/kafka-go"
+
+	"deblockTest/pkg"
+)
+
+// AddressUpdater is implemented by addressBook.AddressBook.
+type AddressUpdater interface {
+	SetAddresses(addresses map[common.Address]pkg.AddressRecord)
+	Add(addresses map[common.Address]pkg.AddressRecord)
+	Remove(addresses ...common.Address)
+}
+
+// Registration is the value of an address-registration event, keyed by the address.
+// A nil value (tombstone) unregisters the address. A shared address lists its Owners instead of a UserID.
+type Registration struct {
+	UserID string            `json:"userId,omitempty"`
+	Owners []string          `json:"owners,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// maxUpdateBatch bounds the events applied to the address book at once: each update copies the book.
+const maxUpdateBatch = 10_000
+
+// messageReader is implemented by kafka.Reader.
+type messageReader interface {
+	ReadMessage(ctx context.Context) (kafka.Message, error)
+}
+
+// AddressFeed keeps an address book in sync with a compacted topic of address registrations.
+// The book is bootstrapped from the start of the topic: it is only filled, and the feed Ready,
+// once every partition was read up to its end offset at startup. Later events update it in batches.
+type AddressFeed struct {
+	config *Config
+	book   AddressUpdater
+	ready  chan struct{}
+	mu     sync.Mutex
+	// offsets is the next offset to consume, by partition.
+	offsets map[int]int64
+}
+
+func NewAddressFeed(cfg *Config, book AddressUpdater) *AddressFeed {
+	return &AddressFeed{
+		config:  cfg,
+		book:    book,
+		ready:   make(chan struct{}),
+		offsets: make(map[int]int64),
+	}
+}
+
+// Ready is closed once the address book caught up with the topic.
+func (f *AddressFeed) Ready() <-chan struct{} {
+	return f.ready
+}
+
+// Offsets returns the next offset to consume of each partition.
+func (f *AddressFeed) Offsets() map[int]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return maps.Clone(f.offsets)
+}
+
+// Run consumes the topic from its first offset until ctx is done.
+func (f *AddressFeed) Run(ctx context.Context) error {
+	partitions, err := kafka.LookupPartitions(ctx, "tcp", f.config.Broker, f.config.Topic)
+	if err != nil {
+		return fmt.Errorf("lookup partitions of %s: %w", f.config.Topic, err)
+	}
+
+	readers := make(map[int]messageReader, len(partitions))
+	ends := make(map[int]int64, len(partitions))
+	for _, p := range partitions {
+		first, last, err := f.partitionOffsets(ctx, p.ID)
+		if err != nil {
+			return err
+		}
+		r := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   []string{f.config.Broker},
+			Topic:     f.config.Topic,
+			Partition: p.ID,
+		})
+		defer r.Close()
+		if err := r.SetOffset(first); err != nil {
+			return fmt.Errorf("seek partition %d of %s: %w", p.ID, f.config.Topic, err)
+		}
+		readers[p.ID] = r
+		ends[p.ID] = last
+		f.setOffset(p.ID, first)
+	}
+	return f.consume(ctx, readers, ends)
+}
+
+func (f *AddressFeed) partitionOffsets(ctx context.Context, partition int) (first, last int64, err error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", f.config.Broker, f.config.Topic, partition)
+	if err != nil {
+		return 0, 0, fmt.Errorf("dial leader of partition %d of %s: %w", partition, f.config.Topic, err)
+	}
+	defer conn.Close()
+	first, last, err = conn.ReadOffsets()
+	if err != nil {
+		return 0, 0, fmt.Errorf("read offsets of partition %d of %s: %w", partition, f.config.Topic, err)
+	}
+	return first, last, nil
+}
+
+// consume reads every partition, bootstrapping the book until each reached its end offset.
+func (f *AddressFeed) consume(ctx context.Context, readers map[int]messageReader, ends map[int]int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	msgs := make(chan kafka.Message, maxUpdateBatch)
+	errs := make(chan error, len(readers))
+	for _, r := range readers {
+		go func() {
+			for {
+				m, err := r.ReadMessage(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				select {
+				case msgs <- m:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// behind lists the partitions not yet read up to their end offset at startup.
+	behind := make(map[int]bool)
+	for p, end := range ends {
+		if f.offset(p) < end {
+			behind[p] = true
+		}
+	}
+	bootstrap := make(map[common.Address]pkg.AddressRecord)
+	if len(behind) == 0 {
+		f.bootstrapped(bootstrap)
+	}
+
+	updates := make(map[common.Address]*pkg.AddressRecord)
+	for {
+		var m kafka.Message
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return fmt.Errorf("consume %s: %w", f.config.Topic, err)
+		case m = <-msgs:
+		}
+
+		// Drain what is already there, to update the book in batches.
+		for {
+			f.read(m, bootstrap, updates, behind)
+			if behind[m.Partition] && m.Offset+1 >= ends[m.Partition] {
+				delete(behind, m.Partition)
+				if len(behind) == 0 {
+					f.bootstrapped(bootstrap)
+					bootstrap = nil
+				}
+			}
+			if len(updates) >= maxUpdateBatch || len(msgs) == 0 {
+				break
+			}
+			m = <-msgs
+		}
+		f.apply(updates)
+	}
+}
+
+// read records m in the bootstrap map while the book is not ready, in updates after.
+func (f *AddressFeed) read(m kafka.Message, bootstrap map[common.Address]pkg.AddressRecord, updates map[common.Address]*pkg.AddressRecord, behind map[int]bool) {
+	f.setOffset(m.Partition, m.Offset+1)
+
+	addr, record, err := parseRegistration(m)
+	if err != nil {
+		log.Printf("Skipping address registration at %d/%d: %v", m.Partition, m.Offset, err)
+		return
+	}
+	if bootstrap != nil && len(behind) > 0 {
+		if record == nil {
+			delete(bootstrap, addr)
+		} else {
+			bootstrap[addr] = *record
+		}
+		return
+	}
+	updates[addr] = record
+}
+
+func (f *AddressFeed) bootstrapped(addresses map[common.Address]pkg.AddressRecord) {
+	f.book.SetAddresses(addresses)
+	log.Printf("Loaded %d addresses from %s", len(addresses), f.config.Topic)
+	close(f.ready)
+}
+
+// apply adds and removes the addresses of updates, then empties it.
+func (f *AddressFeed) apply(updates map[common.Address]*pkg.AddressRecord) {
+	added := make(map[common.Address]pkg.AddressRecord)
+	var removed []common.Address
+	for addr, record := range updates {
+		if record == nil {
+			removed = append(removed, addr)
+		} else {
+			added[addr] = *record
+		}
+	}
+	if len(added) > 0 {
+		f.book.Add(added)
+	}
+	if len(removed) > 0 {
+		f.book.Remove(removed...)
+	}
+	clear(updates)
+}
+
+// parseRegistration returns the address of m, and its record or nil for a tombstone.
+func parseRegistration(m kafka.Message) (common.Address, *pkg.AddressRecord, error) {
+	key := string(m.Key)
+	if !common.IsHexAddress(key) {
+		return common.Address{}, nil, fmt.Errorf("invalid address key %q", key)
+	}
+	addr := common.HexToAddress(key)
+	if m.Value == nil {
+		return addr, nil, nil
+	}
+
+	var r Registration
+	if err := json.Unmarshal(m.Value, &r); err != nil {
+		return addr, nil, err
+	}
+	owners := r.Owners
+	if r.UserID != "" && !slices.Contains(owners, r.UserID) {
+		owners = append([]string{r.UserID}, owners...)
+	}
+	if len(owners) == 0 {
+		return addr, nil, fmt.Errorf("%s has no owner", addr.Hex())
+	}
+	return addr, &pkg.AddressRecord{Owners: owners, Labels: r.Labels}, nil
+}
+
+func (f *AddressFeed) offset(partition int) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.offsets[partition]
+}
+
+func (f *AddressFeed) setOffset(partition int, offset int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.offsets[partition] = offset
+}
@@ -0,0 +1,132 @@
+package kafka
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/segmentio/kafka-go"
+
+	"deblockTest/pkg"
+)
+
+// fakeReader serves the messages of one partition, then blocks until more are sent.
+type fakeReader chan kafka.Message
+
+func (r fakeReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-r:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+type fakeBook struct {
+	mu        sync.Mutex
+	addresses map[common.Address]pkg.AddressRecord
+}
+
+func (b *fakeBook) SetAddresses(addresses map[common.Address]pkg.AddressRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addresses = maps.Clone(addresses)
+}
+
+func (b *fakeBook) Add(addresses map[common.Address]pkg.AddressRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	maps.Copy(b.addresses, addresses)
+}
+
+func (b *fakeBook) Remove(addresses ...common.Address) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, addr := range addresses {
+		delete(b.addresses, addr)
+	}
+}
+
+func (b *fakeBook) get(addr common.Address) (pkg.AddressRecord, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	record, ok := b.addresses[addr]
+	return record, ok
+}
+
+func registration(partition int, offset int64, addr common.Address, value string) kafka.Message {
+	m := kafka.Message{Partition: partition, Offset: offset, Key: []byte(addr.Hex())}
+	if value != "" {
+		m.Value = []byte(value)
+	}
+	return m
+}
+
+func TestAddressFeed(t *testing.T) {
+	a := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	b := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	c := common.HexToAddress("0x00000000000000000000000000000000000000cc")
+
+	p0, p1 := make(fakeReader, 10), make(fakeReader, 10)
+	p0 <- registration(0, 0, a, `{"userId": "user-1"}`)
+	p0 <- registration(0, 1, b, `{"userId": "user-2"}`)
+	p0 <- registration(0, 2, a, "") // tombstone
+	p1 <- registration(1, 0, c, `not json`)
+
+	book := &fakeBook{}
+	feed := NewAddressFeed(&Config{Topic: "addresses"}, book)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- feed.consume(ctx, map[int]messageReader{0: p0, 1: p1}, map[int]int64{0: 3, 1: 2})
+	}()
+
+	// Partition 1 is not caught up yet.
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-feed.Ready():
+		t.Fatal("expected the feed not to be ready before every partition is read")
+	default:
+	}
+
+	p1 <- registration(1, 1, c, `{"userId": "user-3"}`)
+	select {
+	case <-feed.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the feed to be ready")
+	}
+	if _, ok := book.get(a); ok {
+		t.Error("expected the tombstoned address to be skipped")
+	}
+	if record, _ := book.get(c); !slices.Equal(record.Owners, []string{"user-3"}) {
+		t.Errorf("expected %s to belong to user-3, got %v", c.Hex(), record.Owners)
+	}
+	if offsets := feed.Offsets(); offsets[0] != 3 || offsets[1] != 2 {
+		t.Errorf("unexpected offsets %v", offsets)
+	}
+
+	// Updates after the bootstrap.
+	p0 <- registration(0, 3, b, "")
+	p1 <- registration(1, 2, a, `{"owners": ["user-4", "user-5"], "labels": {"walletType": "omnibus"}}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, hasB := book.get(b)
+		record, _ := book.get(a)
+		if !hasB && len(record.Owners) == 2 && record.Labels[pkg.LabelWalletType] == "omnibus" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the updates")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected a clean stop, got %v", err)
+	}
+}
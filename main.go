@@ -3,34 +3,280 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"io/fs"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 
 	"deblockTest/addressBook"
+	"deblockTest/batch"
 	"deblockTest/checkpoint"
 	"deblockTest/kafka"
+	"deblockTest/mempool"
+	"deblockTest/pkg"
+	"deblockTest/provider"
+	"deblockTest/ratelimit"
+	"deblockTest/registry"
 	service2 "deblockTest/service"
+	"deblockTest/source"
+	"deblockTest/tracer"
 )
 
 const (
-	rpcURL         = "https://eth-mainnet.g.alchemy.com/v2/"
-	kafkaBroker    = "localhost:9092"
-	kafkaTopic     = "eth-transactions"
-	checkpointFile = "checkpoint.txt"
-	workerCount    = 4
-	bloomExpected  = 600_000 // slighty larger than number of addresses, to keep some bit at 0 (otherwise 100% false positive).
-	bloomFalsePos  = 0.0001
-	pollInterval   = 1 * time.Second
+	kafkaBroker          = "localhost:9092"
+	workerCount          = 4
+	bloomExpected        = 600_000 // slighty larger than number of addresses, to keep some bit at 0 (otherwise 100% false positive).
+	bloomFalsePos        = 0.0001
+	reorgDepth           = 64       // post-merge reorgs are rarely deeper than a couple of blocks.
+	headTag              = "latest" // "latest", "safe" or "finalized".
+	traceMethod          = ""       // "debug" or "parity" to detect internal ETH transfers, empty to disable.
+	maxHeadLag           = 3        // blocks a provider may trail the others before being demoted.
+	probeInterval        = 5 * time.Second
+	addressCheckInterval = time.Minute
+	metricsInterval      = time.Minute
+	// addressReloadInterval is how often a source which cannot tell whether it changed is read again.
+	addressReloadInterval = 10 * time.Minute
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		backfill(os.Args[2:])
+		return
+	}
+
+	flags := flag.NewFlagSet("deblockTest", flag.ExitOnError)
+	chainsFile := flags.String("chains", "chains.json", "chain registry")
+	backfillRange := flags.String("backfill", "", "re-index chain:from-to next to live ingestion, with the compute units it leaves")
+	controlAddr := flags.String("control", "localhost:8090", "address of the control endpoint, which starts backfills (disabled when empty)")
+	addresses := addressFlags(flags)
+	_ = flags.Parse(os.Args[1:])
+
+	chains := loadChains(*chainsFile, flags.Arg(0))
+	if *backfillRange != "" {
+		if _, _, _, err := parseBackfillRange(chains, *backfillRange); err != nil {
+			log.Fatal(err)
+		}
+	}
+	ctx := shutdownContext()
+	ab, ready := loadAddressBook(ctx, addresses)
+	limiter := ratelimit.NewFromConfig(&ratelimit.Config{ComputeUnitsPerSecond: chains.ComputeUnitsPerSecond})
+	log.Printf("Live ingestion needs about %.0f of the %.0f compute units per second", chains.ComputeUnits(), chains.ComputeUnitsPerSecond)
+
+	// One pipeline per chain, all watching the same addresses.
+	var wg sync.WaitGroup
+	for _, c := range chains.Chains {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runChain(ctx, c, ab, ready, limiter)
+		}()
+	}
+
+	// Backfills share the limiter of the live pipelines, their requests give way to theirs.
+	bf := &backfills{ctx: ctx, chains: chains, ab: ab, ready: ready, limiter: limiter, running: make(map[string]bool)}
+	if *backfillRange != "" {
+		_ = bf.start(*backfillRange)
+	}
+	if *controlAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/backfill", bf)
+		go serveControl(ctx, *controlAddr, mux)
+	}
+	wg.Wait()
+}
+
+// backfill re-indexes a block range of one chain, with the whole compute budget:
+//
+//	go run . backfill -from X -to Y [-chain ethereum] YOUR_ALCHEMY_KEY
+//
+// Next to live ingestion, POST the range to its control endpoint instead: two processes would each
+// spend the whole budget.
+func backfill(args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	chainsFile := flags.String("chains", "chains.json", "chain registry")
+	chainName := flags.String("chain", "ethereum", "name of the chain to backfill")
+	from := flags.Uint64("from", 0, "first block to re-index")
+	to := flags.Uint64("to", 0, "last block to re-index")
+	checkpointFile := flags.String("checkpoint", "", "progress checkpoint, defaults to backfill-<chain>-<from>-<to>.txt")
+	addresses := addressFlags(flags)
+	_ = flags.Parse(args)
+
+	if *to == 0 || *from > *to {
+		log.Fatal("backfill needs a valid -from and -to block range")
+	}
+
+	chains := loadChains(*chainsFile, flags.Arg(0))
+	c, err := chainByName(chains, *chainName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := ratelimit.WithPriority(shutdownContext(), ratelimit.Low)
+	ab, ready := loadAddressBook(ctx, addresses)
+	limiter := ratelimit.NewFromConfig(&ratelimit.Config{ComputeUnitsPerSecond: chains.ComputeUnitsPerSecond})
+
+	if err := runBackfill(ctx, c, *from, *to, *checkpointFile, ab, ready, limiter); err != nil {
+		log.Fatalf("Backfill interrupted: %v", err)
+	}
+}
+
+// runBackfill re-indexes the blocks from `from` to `to` of a chain, checkpointFile defaults to
+// backfill-<chain>-<from>-<to>.txt. Its requests have the priority of ctx.
+func runBackfill(ctx context.Context, c *registry.Chain, from, to uint64, checkpointFile string, ab *addressBook.AddressBook, ready service2.Readiness, limiter *ratelimit.Limiter) error {
+	if checkpointFile == "" {
+		checkpointFile = fmt.Sprintf("backfill-%s-%d-%d.txt", c.Name, from, to)
+	}
+
+	k := kafka.NewFromConfig(&kafka.Config{Broker: kafkaBroker, Topic: c.Topic})
+	defer k.Close()
+
+	client, rpcClient := dial(ctx, c, limiter)
+	defer rpcClient.Close()
+
+	// The backfill has its own checkpoint, the live one is never moved.
+	s := checkpoint.NewFromConfig(checkpoint.Config{File: checkpointFile})
+	service := service2.NewService(serviceConfig(c), client, ab, k, s)
+	service.WaitFor(ready)
+	if traceMethod != "" {
+		service.SetTracer(tracer.NewFromConfig(&tracer.Config{Method: traceMethod}, rpcClient))
+	}
+
+	service.Setup(ctx)
+	return service.Backfill(ctx, from, to)
+}
+
+func runChain(ctx context.Context, c registry.Chain, ab *addressBook.AddressBook, ready service2.Readiness, limiter *ratelimit.Limiter) {
+	k := kafka.NewFromConfig(&kafka.Config{Broker: kafkaBroker, Topic: c.Topic})
+	defer k.Close()
+
+	client, rpcClient := dial(ctx, &c, limiter)
+	defer rpcClient.Close()
+
+	s := checkpoint.NewFromConfig(checkpoint.Config{File: c.CheckpointFile})
+
+	cfg := serviceConfig(&c)
+	cfg.ReorgDepth = reorgDepth
+	service := service2.NewService(cfg, client, ab, k, s)
+	service.WaitFor(ready)
+
+	if traceMethod != "" {
+		service.SetTracer(tracer.NewFromConfig(&tracer.Config{Method: traceMethod}, rpcClient))
+	}
+
+	if c.WSURL != "" {
+		wsClient, err := rpc.DialContext(ctx, c.WSURL)
+		if err != nil {
+			log.Printf("%s: WebSocket unavailable, falling back to polling: %v", c.Name, err)
+		} else {
+			defer wsClient.Close()
+			service.SubscribeHeads(ethclient.NewClient(wsClient))
+
+			if c.WatchMempool {
+				watcher := mempool.NewFromConfig(&mempool.Config{ChainID: c.ChainID},
+					mempool.RPCSubscriber{Client: wsClient}, ethclient.NewClient(rpcClient), ab, k)
+				service.SetBlockObserver(watcher)
+				go watcher.Run(ctx)
+			}
+		}
+	}
+
+	log.Printf("Watching %s (chain id %d)", c.Name, c.ChainID)
+	go reportMetrics(ctx, c.Name, service.Metrics())
+	service.Setup(ctx)
+	service.Run(ctx)
+}
+
+// reportMetrics logs the counters of a chain every metricsInterval.
+func reportMetrics(ctx context.Context, name string, m *service2.Metrics) {
+	ticker := time.NewTicker(metricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Printf("%s: %d transactions with an unrecoverable sender so far", name, m.SenderRecoveryFailures.Load())
+		}
+	}
+}
+
+func serviceConfig(c *registry.Chain) *service2.Config {
+	return &service2.Config{
+		ChainID:        c.ChainID,
+		PollInterval:   time.Duration(c.PollInterval),
+		WorkerCount:    workerCount,
+		CheckpointFile: c.CheckpointFile,
+		Confirmations:  c.Confirmations,
+		HeadTag:        headTag,
+		FetchReceipts:  c.FetchReceipts,
+		BatchSize:      c.BatchSize,
+	}
+}
+
+// dial connects to every provider of a chain. It also returns the connection to the main provider,
+// for the calls that are not spread over the pool such as traces.
+func dial(ctx context.Context, c *registry.Chain, limiter *ratelimit.Limiter) (*provider.Pool, *rpc.Client) {
+	httpClient := rpc.WithHTTPClient(&http.Client{Transport: limiter.Transport(http.DefaultTransport)})
+	var providers []provider.Provider
+	var primary *rpc.Client
+	for _, endpoint := range append([]string{c.RPCURL}, c.FallbackRPCURLs...) {
+		client, err := rpc.DialOptions(ctx, endpoint, httpClient)
+		if err != nil {
+			log.Fatalf("%s: %v", c.Name, err)
+		}
+		if primary == nil {
+			primary = client
+		}
+		providers = append(providers, provider.Provider{Name: providerName(c, endpoint), Client: batch.NewClient(client)})
+	}
+
+	pool := provider.NewFromConfig(&provider.Config{
+		MaxHeadLag:    maxHeadLag,
+		ProbeInterval: probeInterval,
+		Quorum:        c.Quorum,
+		VerifyBodies:  c.VerifyBodies,
+	}, providers)
+	go pool.Run(ctx)
+	return pool, primary
+}
+
+// providerName identifies a provider in logs without its API key.
+func providerName(c *registry.Chain, rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return c.Name + "/" + u.Host
+	}
+	return c.Name
+}
+
+func loadChains(path, apiKey string) *registry.Config {
+	// The API key can also be given as first argument, as before the chain registry.
+	if apiKey != "" {
+		_ = os.Setenv("ALCHEMY_API_KEY", apiKey)
+	}
+	if os.Getenv("ALCHEMY_API_KEY") == "" {
+		log.Fatal("missing eth api key")
+	}
+
+	chains, err := registry.Load(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return chains
+}
+
+func shutdownContext() context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		sig := make(chan os.Signal, 1)
@@ -39,48 +285,148 @@ func main() {
 		log.Println("Shutting down...")
 		cancel()
 	}()
+	return ctx
+}
+
+// addressSource is where the watched addresses are read from, see source.Parse.
+type addressSource struct {
+	spec, query, fingerprintQuery, snapshot *string
+}
+
+func addressFlags(flags *flag.FlagSet) addressSource {
+	return addressSource{
+		spec:  flags.String("addresses", "", "watched addresses: a .csv or .jsonl file, an http(s) URL, postgres://, sqlite:// or kafka://broker/topic (simulated when empty)"),
+		query: flags.String("addresses-query", source.DefaultQuery, "query of a SQL address source"),
+		fingerprintQuery: flags.String("addresses-fingerprint-query", "", "query whose result changes with a SQL address source, "+
+			"e.g. SELECT count(*), max(updated_at) FROM addresses (reloaded every 10 minutes when empty)"),
+		snapshot: flags.String("addresses-snapshot", "addresses.snap", "address book snapshot, loaded at startup instead of the source (disabled when empty)"),
+	}
+}
 
-	// Setup
-	addresses := loadAddresses()
+// loadAddressBook returns the address book, and when it follows a Kafka topic, a gate that opens
+// once it caught up with the topic.
+func loadAddressBook(ctx context.Context, src addressSource) (*addressBook.AddressBook, service2.Readiness) {
 	ab := addressBook.NewFromConfig(&addressBook.Config{
 		BloomExpected: bloomExpected,
 		BloomFalsePos: bloomFalsePos,
 	})
-	ab.SetAddresses(addresses)
 
-	k := kafka.NewFromConfig(&kafka.Config{Broker: kafkaBroker, Topic: kafkaTopic})
-	defer k.Close()
-
-	if len(os.Args) < 2 {
-		log.Fatal("missing eth api key")
+	if u, err := url.Parse(*src.spec); err == nil && u.Scheme == "kafka" {
+		feed := kafka.NewAddressFeed(&kafka.Config{Broker: u.Host, Topic: strings.TrimPrefix(u.Path, "/")}, ab)
+		go func() {
+			if err := feed.Run(ctx); err != nil {
+				log.Fatalf("Address feed stopped: %v", err)
+			}
+		}()
+		return ab, feed
+	}
+	if *src.spec == "" {
+		ab.SetAddresses(simulateAddresses())
+		return ab, nil
 	}
 
-	client, err := ethclient.Dial(rpcURL + os.Args[1])
+	// SQL sources keep their connection open, for the reloads.
+	s, err := source.Parse(*src.spec, *src.query)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer client.Close()
+	if sqlSource, ok := s.(source.SQL); ok {
+		sqlSource.FingerprintQuery = *src.fingerprintQuery
+		s = sqlSource
+	}
 
-	s := checkpoint.NewFromConfig(checkpoint.Config{File: checkpointFile})
+	// Starting from the snapshot, the source is checked in the background.
+	var fingerprint string
+	stale := false
+	if *src.snapshot != "" {
+		fingerprint, err = ab.LoadSnapshot(*src.snapshot)
+		switch {
+		case err == nil:
+			log.Printf("Loaded %d addresses from snapshot %s", ab.Len(), *src.snapshot)
+			stale = true
+		case !errors.Is(err, fs.ErrNotExist):
+			log.Printf("Ignoring address book snapshot %s: %v", *src.snapshot, err)
+		}
+	}
+	if !stale {
+		if fingerprint, err = reloadAddresses(ctx, ab, s, src); err != nil {
+			log.Fatalf("Failed to load addresses from %s: %v", *src.spec, err)
+		}
+	}
+	go refreshAddresses(ctx, ab, s, src, fingerprint, stale)
+	return ab, nil
+}
+
+// reloadAddresses reads the whole source into the book and saves its snapshot.
+// It returns the fingerprint of the source, see addressFingerprint.
+func reloadAddresses(ctx context.Context, ab *addressBook.AddressBook, s source.Source, src addressSource) (string, error) {
+	// Taken before the read, so that a change made meanwhile is caught by the next check.
+	fingerprint, err := addressFingerprint(ctx, s, src)
+	if err != nil {
+		log.Printf("Failed to fingerprint address source %s: %v", *src.spec, err)
+	}
+	m, err := source.Load(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	ab.SetAddresses(m)
+	log.Printf("Loaded %d addresses", len(m))
 
-	service := service2.NewService(
-		&service2.Config{PollInterval: pollInterval, WorkerCount: workerCount, CheckpointFile: checkpointFile},
-		client,
-		ab,
-		k,
-		s,
-	)
+	if *src.snapshot != "" {
+		if err := ab.SaveSnapshot(*src.snapshot, fingerprint); err != nil {
+			log.Printf("Failed to save address book snapshot %s: %v", *src.snapshot, err)
+		}
+	}
+	return fingerprint, nil
+}
 
-	service.Setup(ctx)
-	service.Run(ctx)
+// refreshAddresses rebuilds the book, and its snapshot, whenever the fingerprint of the source changes.
+// A source without fingerprint, such as SQL without fingerprint query, is reloaded every addressReloadInterval,
+// and right away when the book comes from a stale snapshot.
+func refreshAddresses(ctx context.Context, ab *addressBook.AddressBook, s source.Source, src addressSource, fingerprint string, stale bool) {
+	ticker := time.NewTicker(addressCheckInterval)
+	defer ticker.Stop()
+	loaded := time.Now()
+	for {
+		current, err := addressFingerprint(ctx, s, src)
+		switch {
+		case err != nil:
+			log.Printf("Failed to check address source %s: %v", *src.spec, err)
+		case current != fingerprint || current == "" && (stale || time.Since(loaded) >= addressReloadInterval):
+			if current != "" {
+				log.Printf("Address source %s changed, reloading", *src.spec)
+			}
+			if fingerprint, err = reloadAddresses(ctx, ab, s, src); err != nil {
+				log.Printf("Failed to reload addresses from %s, keeping the previous ones: %v", *src.spec, err)
+			} else {
+				stale = false
+				loaded = time.Now()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// addressFingerprint identifies the source and its content, "" when the source cannot tell whether it changed.
+func addressFingerprint(ctx context.Context, s source.Source, src addressSource) (string, error) {
+	fingerprint, err := source.Fingerprint(ctx, s)
+	if err != nil || fingerprint == "" {
+		return "", err
+	}
+	return *src.spec + " " + *src.query + " " + *src.fingerprintQuery + " " + fingerprint, nil
 }
 
-func loadAddresses() map[common.Address]string {
+func simulateAddresses() map[common.Address]pkg.AddressRecord {
 	// Simulate 500k addresses
-	m := make(map[common.Address]string, 500_000)
+	m := make(map[common.Address]pkg.AddressRecord, 500_000)
 	for i := 0; i < 500_000; i++ {
 		addr := common.HexToAddress(fmt.Sprintf("0x%040x", i+1))
-		m[addr] = fmt.Sprintf("user-%d", i+1)
+		m[addr] = pkg.Owner(fmt.Sprintf("user-%d", i+1))
 	}
 	log.Printf("Loaded %d addresses", len(m))
 
@@ -0,0 +1,16 @@
+package mempool
+
+import "time"
+
+type Config struct {
+	// ChainID is the id of the followed chain, used to recover senders. Defaults to 1 (mainnet).
+	ChainID uint64
+	// CheckInterval is how often pending transactions are checked to detect the dropped ones.
+	CheckInterval time.Duration
+	// DropAfter is how long a transaction must have been pending before being checked.
+	DropAfter time.Duration
+	// StuckAfter is how long a transaction of a watched sender may wait behind a nonce gap before being reported stuck.
+	StuckAfter time.Duration
+	// ResubscribeInterval is how long to wait before retrying a dropped subscription.
+	ResubscribeInterval time.Duration
+}
@@ -0,0 +1,263 @@
+package mempool
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"deblockTest/pkg"
+)
+
+// PendingSubscriber streams the full body of the transactions entering the mempool.
+type PendingSubscriber interface {
+	SubscribePendingTransactions(ctx context.Context, ch chan<- *types.Transaction) (ethereum.Subscription, error)
+}
+
+// NodeClient is implemented by ethclient.Client.
+type NodeClient interface {
+	TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+}
+
+type UserGetter interface {
+	Lookup(addr common.Address) (pkg.AddressRecord, bool)
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, msgs []pkg.TxMessage)
+}
+
+// RPCSubscriber subscribes to newPendingTransactions with full bodies, over a WebSocket connection.
+type RPCSubscriber struct {
+	Client *rpc.Client
+}
+
+func (s RPCSubscriber) SubscribePendingTransactions(ctx context.Context, ch chan<- *types.Transaction) (ethereum.Subscription, error) {
+	return s.Client.EthSubscribe(ctx, ch, "newPendingTransactions", true)
+}
+
+type pendingTx struct {
+	msgs []pkg.TxMessage
+	seen time.Time
+	// sender and nonce are set when the sender is watched, its nonces are then tracked.
+	sender *common.Address
+	nonce  uint64
+	stuck  bool
+}
+
+// Watcher publishes a pending message for the mempool transactions of watched addresses.
+// A pending transaction is resolved either when the service processes its block, the block messages
+// being its outcome, or when the node forgets it, and a dropped message is published.
+// The nonces of watched senders are tracked too, to report replaced and stuck transactions.
+type Watcher struct {
+	config     *Config
+	subscriber PendingSubscriber
+	client     NodeClient
+	userGetter UserGetter
+	publisher  Publisher
+	signer     types.Signer
+	mu         sync.Mutex
+	pending    map[common.Hash]*pendingTx
+	// nonces maps the watched senders to the hash of their pending transaction for each nonce.
+	nonces map[common.Address]map[uint64]common.Hash
+}
+
+func NewFromConfig(cfg *Config, s PendingSubscriber, client NodeClient, ug UserGetter, p Publisher) *Watcher {
+	if cfg.ChainID == 0 {
+		cfg.ChainID = 1
+	}
+	if cfg.CheckInterval == 0 {
+		cfg.CheckInterval = time.Minute
+	}
+	if cfg.DropAfter == 0 {
+		cfg.DropAfter = 5 * time.Minute
+	}
+	if cfg.StuckAfter == 0 {
+		cfg.StuckAfter = 10 * time.Minute
+	}
+	if cfg.ResubscribeInterval == 0 {
+		cfg.ResubscribeInterval = 30 * time.Second
+	}
+	return &Watcher{
+		config:     cfg,
+		subscriber: s,
+		client:     client,
+		userGetter: ug,
+		publisher:  p,
+		signer:     types.LatestSignerForChainID(new(big.Int).SetUint64(cfg.ChainID)),
+		pending:    make(map[common.Hash]*pendingTx),
+		nonces:     make(map[common.Address]map[uint64]common.Hash),
+	}
+}
+
+// Run follows the mempool until ctx is done, resubscribing when the subscription drops.
+func (w *Watcher) Run(ctx context.Context) {
+	go w.checkDropped(ctx)
+
+	for ctx.Err() == nil {
+		txs := make(chan *types.Transaction, 1024)
+		sub, err := w.subscriber.SubscribePendingTransactions(ctx, txs)
+		if err != nil {
+			log.Printf("newPendingTransactions subscription failed, retrying in %s: %v", w.config.ResubscribeInterval, err)
+		} else {
+			err = w.follow(ctx, sub, txs)
+			sub.Unsubscribe()
+			if err == nil {
+				return
+			}
+			log.Printf("newPendingTransactions subscription dropped, retrying in %s: %v", w.config.ResubscribeInterval, err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.config.ResubscribeInterval):
+		}
+	}
+}
+
+func (w *Watcher) follow(ctx context.Context, sub ethereum.Subscription, txs <-chan *types.Transaction) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-sub.Err():
+			return err
+		case tx := <-txs:
+			w.handle(ctx, tx)
+		}
+	}
+}
+
+// handle publishes a pending message for each owner of the watched parties of tx.
+func (w *Watcher) handle(ctx context.Context, tx *types.Transaction) {
+	var from *common.Address
+	if sender, err := types.Sender(w.signer, tx); err == nil {
+		from = &sender
+	}
+	to := tx.To()
+
+	nonce := tx.Nonce()
+	msg := pkg.TxMessage{
+		ChainID:   w.config.ChainID,
+		From:      pkg.ToStringPtr(from),
+		To:        pkg.ToStringPtr(to),
+		Amount:    tx.Value().String(),
+		Hash:      tx.Hash().Hex(),
+		Event:     pkg.EventPending,
+		Type:      pkg.TypeTransfer,
+		AssetType: pkg.AssetNative,
+		Nonce:     &nonce,
+	}
+	p := &pendingTx{seen: time.Now(), nonce: nonce}
+	for _, addr := range []*common.Address{from, to} {
+		if addr == nil {
+			continue
+		}
+		record, ok := w.userGetter.Lookup(*addr)
+		if !ok {
+			continue
+		}
+		msg.Labels = record.Labels
+		for _, owner := range record.Owners {
+			msg.UserID = owner
+			p.msgs = append(p.msgs, msg)
+		}
+		if addr == from {
+			p.sender = from
+		}
+	}
+	if len(p.msgs) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	if _, known := w.pending[tx.Hash()]; known {
+		// Nodes re-announce transactions, e.g. after a reorg.
+		w.mu.Unlock()
+		return
+	}
+	w.pending[tx.Hash()] = p
+	replaced := w.track(tx.Hash(), p)
+	w.mu.Unlock()
+
+	w.publisher.Publish(ctx, p.msgs)
+	if len(replaced) > 0 {
+		w.publisher.Publish(ctx, replaced)
+	}
+}
+
+// ObserveBlock resolves the pending transactions mined in block, and those replaced by a mined
+// transaction of the same sender and nonce. It is called by the service for every processed block,
+// see service.BlockObserver.
+func (w *Watcher) ObserveBlock(ctx context.Context, block *types.Block) {
+	var replaced []pkg.TxMessage
+	w.mu.Lock()
+	for _, tx := range block.Transactions() {
+		w.remove(tx.Hash())
+		if len(w.nonces) == 0 {
+			continue
+		}
+		if sender, err := types.Sender(w.signer, tx); err == nil {
+			replaced = append(replaced, w.mined(sender, tx)...)
+		}
+	}
+	w.mu.Unlock()
+
+	if len(replaced) > 0 {
+		w.publisher.Publish(ctx, replaced)
+	}
+}
+
+// checkDropped asks the node about the transactions pending for more than DropAfter.
+func (w *Watcher) checkDropped(ctx context.Context) {
+	ticker := time.NewTicker(w.config.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.dropForgotten(ctx)
+			w.reportStuck(ctx)
+		}
+	}
+}
+
+func (w *Watcher) dropForgotten(ctx context.Context) {
+	var old []common.Hash
+	w.mu.Lock()
+	for hash, p := range w.pending {
+		if time.Since(p.seen) >= w.config.DropAfter {
+			old = append(old, hash)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, hash := range old {
+		_, isPending, err := w.client.TransactionByHash(ctx, hash)
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			log.Printf("Failed to check pending transaction %s: %v", hash.Hex(), err)
+			continue
+		}
+		if isPending {
+			continue
+		}
+
+		w.mu.Lock()
+		p := w.remove(hash)
+		w.mu.Unlock()
+		if p == nil || err == nil {
+			// Mined: its outcome is published with its block.
+			continue
+		}
+		w.publisher.Publish(ctx, p.outcome(pkg.EventDropped, ""))
+	}
+}
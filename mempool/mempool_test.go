@@ -0,0 +1,182 @@
+package mempool
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+
+	"deblockTest/pkg"
+)
+
+var (
+	testKey, _  = crypto.GenerateKey()
+	otherKey, _ = crypto.GenerateKey()
+	sender      = crypto.PubkeyToAddress(testKey.PublicKey)
+	watched     = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	stranger    = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+)
+
+type fakeSubscriber struct {
+	txs []*types.Transaction
+}
+
+func (f *fakeSubscriber) SubscribePendingTransactions(_ context.Context, ch chan<- *types.Transaction) (ethereum.Subscription, error) {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		for _, tx := range f.txs {
+			select {
+			case ch <- tx:
+			case <-quit:
+				return nil
+			}
+		}
+		<-quit
+		return nil
+	}), nil
+}
+
+// forgetfulNode knows no transaction at all, and the given next nonce of every account.
+type forgetfulNode struct {
+	nonce uint64
+}
+
+func (forgetfulNode) TransactionByHash(context.Context, common.Hash) (*types.Transaction, bool, error) {
+	return nil, false, ethereum.NotFound
+}
+
+func (n forgetfulNode) NonceAt(context.Context, common.Address, *big.Int) (uint64, error) {
+	return n.nonce, nil
+}
+
+type fakeUsers map[common.Address]string
+
+func (u fakeUsers) Lookup(addr common.Address) (pkg.AddressRecord, bool) {
+	id, ok := u[addr]
+	return pkg.Owner(id), ok
+}
+
+type recordingPublisher struct {
+	mu   sync.Mutex
+	msgs []pkg.TxMessage
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, msgs []pkg.TxMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, msgs...)
+}
+
+func (p *recordingPublisher) events(hash common.Hash) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var events []string
+	for _, m := range p.msgs {
+		if m.Hash == hash.Hex() {
+			events = append(events, m.Event+":"+m.UserID)
+		}
+	}
+	return events
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func send(key *ecdsa.PrivateKey, nonce uint64, to common.Address, gwei int64) *types.Transaction {
+	tx, _ := types.SignTx(types.NewTx(&types.LegacyTx{
+		Nonce: nonce, To: &to, Value: big.NewInt(1e15), Gas: 21000, GasPrice: big.NewInt(gwei * 1e9),
+	}), types.LatestSignerForChainID(big.NewInt(1)), key)
+	return tx
+}
+
+func TestWatcher(t *testing.T) {
+	// An incoming transfer, a transfer by a watched sender, and a transfer between strangers.
+	incoming, outgoing, unrelated := send(otherKey, 0, watched, 1), send(testKey, 0, stranger, 1), send(otherKey, 1, stranger, 1)
+
+	pub := &recordingPublisher{}
+	users := fakeUsers{watched: "user-1", sender: "user-2"}
+	w := NewFromConfig(
+		&Config{CheckInterval: 10 * time.Millisecond, DropAfter: 100 * time.Millisecond},
+		&fakeSubscriber{txs: []*types.Transaction{incoming, incoming, unrelated, outgoing}},
+		forgetfulNode{}, users, pub,
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go w.Run(ctx)
+
+	waitFor(t, "the pending incoming transfer", func() bool { return len(pub.events(incoming.Hash())) > 0 })
+	// Mined before the node could forget it: the block is its outcome.
+	w.ObserveBlock(ctx, types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)}).WithBody(types.Body{Transactions: []*types.Transaction{incoming}}))
+
+	waitFor(t, "the dropped outgoing transfer", func() bool { return len(pub.events(outgoing.Hash())) == 2 })
+
+	if events := pub.events(incoming.Hash()); len(events) != 1 || events[0] != "pending:user-1" {
+		t.Errorf("expected a single pending message for the mined transfer, got %v", events)
+	}
+	if events := pub.events(outgoing.Hash()); events[0] != "pending:user-2" || events[1] != "dropped:user-2" {
+		t.Errorf("expected the outgoing transfer to be pending then dropped, got %v", events)
+	}
+	if events := pub.events(unrelated.Hash()); len(events) != 0 {
+		t.Errorf("expected nothing for an unwatched transfer, got %v", events)
+	}
+}
+
+func TestWatcher_Nonces(t *testing.T) {
+	pub := &recordingPublisher{}
+	w := NewFromConfig(&Config{StuckAfter: time.Nanosecond}, &fakeSubscriber{}, forgetfulNode{nonce: 7}, fakeUsers{sender: "user-2"}, pub)
+	ctx := context.Background()
+
+	original, speedUp := send(testKey, 5, stranger, 1), send(testKey, 5, stranger, 2)
+	next, cancelled := send(testKey, 6, stranger, 1), send(testKey, 6, sender, 3)
+	afterGap := send(testKey, 9, stranger, 1)
+	for _, tx := range []*types.Transaction{original, speedUp, next, afterGap} {
+		w.handle(ctx, tx)
+	}
+	// The speed up and a cancellation of the next nonce are mined.
+	w.ObserveBlock(ctx, types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)}).WithBody(types.Body{
+		Transactions: []*types.Transaction{speedUp, cancelled},
+	}))
+	// Nonces 7 and 8 are missing: the last transaction is stuck, and reported once.
+	w.reportStuck(ctx)
+	w.reportStuck(ctx)
+
+	expected := map[*types.Transaction][]string{
+		original:  {"pending:user-2", "replaced:user-2"},
+		speedUp:   {"pending:user-2"},
+		next:      {"pending:user-2", "replaced:user-2"},
+		cancelled: nil,
+		afterGap:  {"pending:user-2", "stuck:user-2"},
+	}
+	for tx, events := range expected {
+		if got := pub.events(tx.Hash()); !slices.Equal(got, events) {
+			t.Errorf("nonce %d: expected %v, got %v", tx.Nonce(), events, got)
+		}
+	}
+
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	for _, m := range pub.msgs {
+		if m.Event == pkg.EventReplaced && m.Hash == original.Hash().Hex() && m.ReplacedBy != speedUp.Hash().Hex() {
+			t.Errorf("expected the original transaction to be replaced by the speed up, got %s", m.ReplacedBy)
+		}
+		if m.Event == pkg.EventReplaced && m.Hash == next.Hash().Hex() && m.ReplacedBy != cancelled.Hash().Hex() {
+			t.Errorf("expected the next transaction to be replaced by the cancellation, got %s", m.ReplacedBy)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package mempool
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"deblockTest/pkg"
+)
+
+// outcome returns the messages of p for event.
+func (p *pendingTx) outcome(event, replacedBy string) []pkg.TxMessage {
+	msgs := make([]pkg.TxMessage, len(p.msgs))
+	for i, m := range p.msgs {
+		m.Event = event
+		m.ReplacedBy = replacedBy
+		msgs[i] = m
+	}
+	return msgs
+}
+
+// track records the nonce of a pending transaction of a watched sender, and returns the messages of
+// the transaction it replaces, if any. w.mu must be held.
+func (w *Watcher) track(hash common.Hash, p *pendingTx) []pkg.TxMessage {
+	if p.sender == nil {
+		return nil
+	}
+	nonces := w.nonces[*p.sender]
+	if nonces == nil {
+		nonces = make(map[uint64]common.Hash)
+		w.nonces[*p.sender] = nonces
+	}
+
+	var replaced []pkg.TxMessage
+	if previous, ok := nonces[p.nonce]; ok && previous != hash {
+		if old := w.remove(previous); old != nil {
+			replaced = old.outcome(pkg.EventReplaced, hash.Hex())
+		}
+	}
+	nonces[p.nonce] = hash
+	return replaced
+}
+
+// remove forgets a pending transaction and returns it, nil when it was not pending. w.mu must be held.
+func (w *Watcher) remove(hash common.Hash) *pendingTx {
+	p, ok := w.pending[hash]
+	if !ok {
+		return nil
+	}
+	delete(w.pending, hash)
+	if p.sender != nil {
+		if nonces := w.nonces[*p.sender]; nonces[p.nonce] == hash {
+			delete(nonces, p.nonce)
+			if len(nonces) == 0 {
+				delete(w.nonces, *p.sender)
+			}
+		}
+	}
+	return p
+}
+
+// mined resolves the pending transactions of sender which can no longer be mined since tx was:
+// those with the same or a lower nonce. w.mu must be held.
+func (w *Watcher) mined(sender common.Address, tx *types.Transaction) []pkg.TxMessage {
+	var replaced []pkg.TxMessage
+	for nonce, hash := range w.nonces[sender] {
+		if nonce <= tx.Nonce() && hash != tx.Hash() {
+			if p := w.remove(hash); p != nil {
+				replaced = append(replaced, p.outcome(pkg.EventReplaced, tx.Hash().Hex())...)
+			}
+		}
+	}
+	return replaced
+}
+
+// reportStuck reports, once, the transactions of watched senders which have been waiting for more
+// than StuckAfter behind a missing nonce: neither mined nor pending.
+func (w *Watcher) reportStuck(ctx context.Context) {
+	w.mu.Lock()
+	var senders []common.Address
+	for sender, nonces := range w.nonces {
+		for _, hash := range nonces {
+			if p := w.pending[hash]; !p.stuck && time.Since(p.seen) >= w.config.StuckAfter {
+				senders = append(senders, sender)
+				break
+			}
+		}
+	}
+	w.mu.Unlock()
+
+	for _, sender := range senders {
+		next, err := w.client.NonceAt(ctx, sender, nil)
+		if err != nil {
+			log.Printf("Failed to get nonce of %s: %v", sender.Hex(), err)
+			continue
+		}
+
+		var stuck []pkg.TxMessage
+		w.mu.Lock()
+		nonces := w.nonces[sender]
+		for nonce, hash := range nonces {
+			p := w.pending[hash]
+			if p.stuck || time.Since(p.seen) < w.config.StuckAfter || !gap(nonces, next, nonce) {
+				continue
+			}
+			p.stuck = true
+			stuck = append(stuck, p.outcome(pkg.EventStuck, "")...)
+		}
+		w.mu.Unlock()
+
+		if len(stuck) > 0 {
+			w.publisher.Publish(ctx, stuck)
+		}
+	}
+}
+
+// gap reports whether a nonce between the next one to be mined and nonce has no pending transaction.
+func gap(pending map[uint64]common.Hash, next, nonce uint64) bool {
+	if nonce > next && nonce-next > uint64(len(pending)) {
+		return true
+	}
+	for n := next; n < nonce; n++ {
+		if _, ok := pending[n]; !ok {
+			return true
+		}
+	}
+	return false
+}
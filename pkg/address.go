@@ -0,0 +1,21 @@
+package pkg
+
+// Common labels of a watched address.
+const (
+	LabelWalletType = "walletType"
+	LabelRiskTier   = "riskTier"
+	LabelAccountID  = "accountId"
+)
+
+// AddressRecord describes a watched address: its owners, several for shared and omnibus addresses,
+// and labels such as LabelWalletType attached to every message of the address.
+// Records are shared by lookups and must not be modified.
+type AddressRecord struct {
+	Owners []string          `json:"owners"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Owner returns the record of an address with a single owner and no labels.
+func Owner(userID string) AddressRecord {
+	return AddressRecord{Owners: []string{userID}}
+}
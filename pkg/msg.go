@@ -1,10 +1,83 @@
 package pkg
 
+const (
+	// EventMined is emitted when a block is published straight from the head of the chain.
+	EventMined = "mined"
+	// EventSeen is emitted, in two-phase mode, as soon as a transaction is seen at the head of the chain.
+	EventSeen = "seen"
+	// EventConfirmed is emitted once the block of a transaction is buried under the configured confirmation depth.
+	EventConfirmed = "confirmed"
+	// EventRemoved retracts a previously published message whose block was orphaned by a reorg.
+	EventRemoved = "removed"
+	// EventPending is emitted when a transaction is seen in the mempool, before inclusion.
+	// It is followed by the messages of its block, or by EventDropped.
+	EventPending = "pending"
+	// EventDropped is emitted when a pending transaction left the mempool without being mined.
+	EventDropped = "dropped"
+	// EventReplaced is emitted when another transaction of the same sender and nonce replaced a pending one,
+	// e.g. to speed it up or cancel it.
+	EventReplaced = "replaced"
+	// EventStuck is emitted when a pending transaction of a watched sender cannot be mined because
+	// a lower nonce is missing.
+	EventStuck = "stuck"
+)
+
+// Types of message, see TxMessage.Type.
+const (
+	// TypeTransfer is a transfer made by a transaction, directly, through a token contract or an internal call.
+	TypeTransfer = "transfer"
+	// TypeWithdrawal is a beacon-chain withdrawal credited to a watched address, outside of any transaction.
+	TypeWithdrawal = "withdrawal"
+	// TypeReward is what the block fee recipient (coinbase) earned from a block: priority fees and payments.
+	TypeReward = "reward"
+)
+
+// Asset types of a transfer.
+const (
+	AssetNative  = "native"
+	AssetERC20   = "erc20"
+	AssetERC721  = "erc721"
+	AssetERC1155 = "erc1155"
+)
+
 type TxMessage struct {
+	ChainID     uint64 `json:"chainId"`
 	UserID      string `json:"userId"`
 	From        string `json:"from"`
 	To          string `json:"to"`
 	Amount      string `json:"amount"`
 	Hash        string `json:"hash"`
 	BlockNumber uint64 `json:"blockNumber"`
+	BlockHash   string `json:"blockHash"`
+	Event       string `json:"event"`
+	Type        string `json:"type"`
+	AssetType   string `json:"assetType"`
+	// Token is the contract (or NFT collection) of a token transfer, empty for ETH transfers.
+	Token string `json:"token,omitempty"`
+	// TokenID identifies the NFT of an ERC-721 or ERC-1155 transfer, Amount then holds the quantity.
+	TokenID string `json:"tokenId,omitempty"`
+	// LogIndex is the index of the transfer log within the block, for token transfers.
+	LogIndex *uint `json:"logIndex,omitempty"`
+	// TraceAddress locates the call frame of an internal ETH transfer within its transaction.
+	TraceAddress []int `json:"traceAddress,omitempty"`
+	// Status is the receipt status of the transaction: 1 for success, 0 when reverted.
+	// Status, GasUsed, EffectiveGasPrice and Fee are only set when receipts are fetched.
+	Status            *uint64 `json:"status,omitempty"`
+	GasUsed           uint64  `json:"gasUsed,omitempty"`
+	EffectiveGasPrice string  `json:"effectiveGasPrice,omitempty"`
+	// Fee is the total paid by the sender, in wei, including the blob fee.
+	Fee string `json:"fee,omitempty"`
+	// WithdrawalIndex and ValidatorIndex identify a withdrawal, which has no transaction hash.
+	WithdrawalIndex *uint64 `json:"withdrawalIndex,omitempty"`
+	ValidatorIndex  *uint64 `json:"validatorIndex,omitempty"`
+	// PriorityFees and BuilderPayment break down the Amount of a reward, in wei: the tips of the block
+	// transactions, and the ETH sent to the coinbase by the transactions themselves.
+	PriorityFees   string `json:"priorityFees,omitempty"`
+	BuilderPayment string `json:"builderPayment,omitempty"`
+	// Nonce is the sender nonce of a pending transaction.
+	Nonce *uint64 `json:"nonce,omitempty"`
+	// ReplacedBy is the hash of the transaction which replaced a pending one.
+	ReplacedBy string `json:"replacedBy,omitempty"`
+	// Labels are the labels of the watched address, see AddressRecord.
+	Labels map[string]string `json:"labels,omitempty"`
 }
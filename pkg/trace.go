@@ -0,0 +1,16 @@
+package pkg
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// CallFrame is an internal call that moved ETH from one account to another.
+type CallFrame struct {
+	TxHash       common.Hash
+	From         common.Address
+	To           common.Address
+	Value        *big.Int
+	TraceAddress []int
+}
@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// ErrInvalidBody is returned when a provider serves a block or receipts which do not match the block header.
+var ErrInvalidBody = errors.New("body does not match the header roots")
+
+// maxReceiptRoots bounds the receipt roots kept between a block and its receipts requests.
+const maxReceiptRoots = 4096
+
+// checkBody recomputes the transactions and withdrawals roots of block.
+func checkBody(block *types.Block) error {
+	header := block.Header()
+	if root := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); root != header.TxHash {
+		return fmt.Errorf("block %d: transactions root %s instead of %s: %w", block.NumberU64(), root, header.TxHash, ErrInvalidBody)
+	}
+	if header.WithdrawalsHash != nil {
+		if root := types.DeriveSha(block.Withdrawals(), trie.NewStackTrie(nil)); root != *header.WithdrawalsHash {
+			return fmt.Errorf("block %d: withdrawals root %s instead of %s: %w", block.NumberU64(), root, *header.WithdrawalsHash, ErrInvalidBody)
+		}
+	}
+	return nil
+}
+
+// checkReceipts recomputes the receipts root of the block `hash`, when the pool served its header.
+func (p *Pool) checkReceipts(hash common.Hash, receipts []*types.Receipt) error {
+	p.mu.Lock()
+	expected, ok := p.receiptRoots[hash]
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if root := types.DeriveSha(types.Receipts(receipts), trie.NewStackTrie(nil)); root != expected {
+		return fmt.Errorf("block %s: receipts root %s instead of %s: %w", hash, root, expected, ErrInvalidBody)
+	}
+	return nil
+}
+
+// accept checks a block served by provider i, and remembers its receipts root to check its receipts.
+func (p *Pool) accept(i int, block *types.Block) error {
+	if !p.config.VerifyBodies {
+		return nil
+	}
+	if err := checkBody(block); err != nil {
+		log.Printf("Provider %s served an invalid block: %v", p.providers[i].Name, err)
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.receiptRoots) >= maxReceiptRoots {
+		clear(p.receiptRoots)
+	}
+	p.receiptRoots[block.Hash()] = block.ReceiptHash()
+	return nil
+}
@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// bodyClient serves the same block and receipts at every height, truncated when corrupt is set.
+type bodyClient struct {
+	fakeClient
+	block    *types.Block
+	receipts []*types.Receipt
+	corrupt  bool
+}
+
+func (c *bodyClient) BlockByNumber(context.Context, *big.Int) (*types.Block, error) {
+	if err := c.answer(); err != nil {
+		return nil, err
+	}
+	if c.corrupt {
+		return c.block.WithBody(types.Body{}), nil
+	}
+	return c.block, nil
+}
+
+func (c *bodyClient) BlocksByNumber(ctx context.Context, numbers []uint64) ([]*types.Block, []error) {
+	block, err := c.BlockByNumber(ctx, nil)
+	return []*types.Block{block}, []error{err}
+}
+
+func (c *bodyClient) BlockReceipts(context.Context, rpc.BlockNumberOrHash) ([]*types.Receipt, error) {
+	if err := c.answer(); err != nil {
+		return nil, err
+	}
+	if c.corrupt {
+		return c.receipts[:0], nil
+	}
+	return c.receipts, nil
+}
+
+func TestPool_VerifyBodies(t *testing.T) {
+	to := common.HexToAddress("0xaa")
+	tx := types.NewTx(&types.LegacyTx{To: &to, Value: big.NewInt(1), Gas: 21000, GasPrice: big.NewInt(1)})
+	receipts := []*types.Receipt{{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: 21000, Logs: []*types.Log{}}}
+	block := types.NewBlock(&types.Header{Number: big.NewInt(7)}, &types.Body{Transactions: []*types.Transaction{tx}}, receipts, trie.NewStackTrie(nil))
+
+	corrupt := &bodyClient{block: block, receipts: receipts, corrupt: true}
+	sound := &bodyClient{block: block, receipts: receipts}
+	pool := NewFromConfig(&Config{VerifyBodies: true}, []Provider{{"corrupt", corrupt}, {"sound", sound}})
+	ctx := context.Background()
+
+	got, err := pool.BlockByNumber(ctx, big.NewInt(7))
+	if err != nil {
+		t.Fatalf("expected the block to be served by the sound provider, got %v", err)
+	}
+	if len(got.Transactions()) != 1 || sound.calls.Load() != 1 {
+		t.Errorf("expected the full block from the sound provider, got %d transactions", len(got.Transactions()))
+	}
+	if _, err := pool.BlockReceipts(ctx, rpc.BlockNumberOrHashWithHash(block.Hash(), false)); err != nil {
+		t.Fatalf("expected the receipts to be served by the sound provider, got %v", err)
+	}
+
+	// Once the sound provider fails too, nothing invalid is returned.
+	sound.corrupt = true
+	if _, err := pool.BlockByNumber(ctx, big.NewInt(7)); !errors.Is(err, ErrInvalidBody) {
+		t.Errorf("expected an invalid body error, got %v", err)
+	}
+	if _, err := pool.BlockReceipts(ctx, rpc.BlockNumberOrHashWithHash(block.Hash(), false)); !errors.Is(err, ErrInvalidBody) {
+		t.Errorf("expected invalid receipts to be rejected, got %v", err)
+	}
+	if blocks, errs := pool.BlocksByNumber(ctx, []uint64{7}); blocks[0] != nil || !errors.Is(errs[0], ErrInvalidBody) {
+		t.Errorf("expected an invalid block to be left out of the batch, got %v", errs[0])
+	}
+}
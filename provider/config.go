@@ -0,0 +1,20 @@
+package provider
+
+import "time"
+
+type Config struct {
+	// MaxHeadLag is how many blocks a provider may trail the highest known head before being demoted.
+	MaxHeadLag uint64
+	// ProbeInterval is how often Run polls the head of every provider.
+	ProbeInterval time.Duration
+	// ErrorPenalty is the latency added to the score of a provider failing all its requests.
+	// Defaults to 5s: a provider failing half its requests ranks behind one answering in 2.5s.
+	ErrorPenalty time.Duration
+	// Quorum is the number of providers which must agree on the hash of a block before it is returned.
+	// 0 or 1 trusts the provider serving the block.
+	Quorum int
+	// VerifyBodies checks the transactions, withdrawals and receipts served against the block header roots.
+	// A provider serving a mismatching body counts as failing and the request goes to the next one.
+	// Receipts are only checked when their block was fetched through the pool.
+	VerifyBodies bool
+}
@@ -0,0 +1,355 @@
+package provider
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Client is a connection to one provider, such as batch.Client.
+type Client interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error)
+	BlocksByNumber(ctx context.Context, numbers []uint64) ([]*types.Block, []error)
+}
+
+type Provider struct {
+	Name   string
+	Client Client
+}
+
+// Health is what the pool knows about a provider.
+type Health struct {
+	Name string
+	// Latency and ErrorRate are moving averages over the recent requests.
+	Latency   time.Duration
+	ErrorRate float64
+	Head      uint64
+	// Stale is set while the provider trails the highest known head by more than MaxHeadLag.
+	Stale bool
+}
+
+// ErrDisagreement is returned when providers serve different blocks at the same height.
+var ErrDisagreement = errors.New("providers disagree on block hash")
+
+// smoothing is the weight of the last request in the moving averages.
+const smoothing = 0.2
+
+// Pool spreads requests over several providers: each request goes to the healthiest one,
+// and fails over to the next ones until one answers.
+type Pool struct {
+	config        *Config
+	providers     []Provider
+	mu            sync.Mutex
+	health        []Health
+	disagreements atomic.Uint64
+	// receiptRoots maps the hash of the blocks served to their receipts root, see Config.VerifyBodies.
+	receiptRoots map[common.Hash]common.Hash
+}
+
+func NewFromConfig(cfg *Config, providers []Provider) *Pool {
+	if cfg.ErrorPenalty == 0 {
+		cfg.ErrorPenalty = 5 * time.Second
+	}
+	health := make([]Health, len(providers))
+	for i, p := range providers {
+		health[i].Name = p.Name
+	}
+	return &Pool{config: cfg, providers: providers, health: health, receiptRoots: make(map[common.Hash]common.Hash)}
+}
+
+// Run probes the head of every provider every ProbeInterval, so that lagging ones are demoted
+// even when they are not serving requests. It returns when ctx is done.
+func (p *Pool) Run(ctx context.Context) {
+	for {
+		var wg sync.WaitGroup
+		for i := range p.providers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				probeCtx, cancel := context.WithTimeout(ctx, p.config.ProbeInterval)
+				defer cancel()
+				start := time.Now()
+				head, err := p.providers[i].Client.BlockNumber(probeCtx)
+				p.observe(i, start, err)
+				if err == nil {
+					p.observeHead(i, head)
+				}
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.config.ProbeInterval):
+		}
+	}
+}
+
+// Health returns a snapshot of the providers, in configuration order.
+func (p *Pool) Health() []Health {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.health)
+}
+
+func (p *Pool) BlockNumber(ctx context.Context) (uint64, error) {
+	var head uint64
+	err := p.do(ctx, 0, func(i int, c Client) error {
+		var err error
+		if head, err = c.BlockNumber(ctx); err == nil {
+			p.observeHead(i, head)
+		}
+		return err
+	})
+	return head, err
+}
+
+// Disagreements returns how many blocks were held because providers served different hashes.
+func (p *Pool) Disagreements() uint64 {
+	return p.disagreements.Load()
+}
+
+func (p *Pool) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	var block *types.Block
+	var served int
+	err := p.do(ctx, blockNumber(number), func(i int, c Client) error {
+		var err error
+		if block, err = c.BlockByNumber(ctx, number); err != nil {
+			return err
+		}
+		served = i
+		return p.accept(i, block)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := p.verify(ctx, served, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (p *Pool) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var header *types.Header
+	err := p.do(ctx, blockNumber(number), func(_ int, c Client) error {
+		var err error
+		header, err = c.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+func (p *Pool) BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error) {
+	var receipts []*types.Receipt
+	var number uint64
+	if n, ok := blockNrOrHash.Number(); ok && n >= 0 {
+		number = uint64(n)
+	}
+	err := p.do(ctx, number, func(_ int, c Client) error {
+		var err error
+		if receipts, err = c.BlockReceipts(ctx, blockNrOrHash); err != nil {
+			return err
+		}
+		if hash, ok := blockNrOrHash.Hash(); ok && p.config.VerifyBodies {
+			return p.checkReceipts(hash, receipts)
+		}
+		return nil
+	})
+	return receipts, err
+}
+
+// BlocksByNumber sends the whole batch to the healthiest provider. It only fails over when the
+// batch fails as a whole, blocks failing on their own are left to be fetched one by one.
+func (p *Pool) BlocksByNumber(ctx context.Context, numbers []uint64) ([]*types.Block, []error) {
+	var blocks []*types.Block
+	var errs []error
+	var served int
+	err := p.do(ctx, slices.Max(numbers), func(i int, c Client) error {
+		blocks, errs = c.BlocksByNumber(ctx, numbers)
+		served = i
+		for _, err := range errs {
+			if err == nil {
+				return nil
+			}
+		}
+		return errs[0]
+	})
+	if err != nil && errs == nil {
+		errs = make([]error, len(numbers))
+		for i := range errs {
+			errs[i] = err
+		}
+		blocks = make([]*types.Block, len(numbers))
+	}
+	for i, block := range blocks {
+		if errs[i] != nil {
+			continue
+		}
+		if errs[i] = p.accept(served, block); errs[i] != nil {
+			// Fetched again on its own, by the next provider.
+			p.penalize(served)
+		} else {
+			errs[i] = p.verify(ctx, served, block)
+		}
+		if errs[i] != nil {
+			blocks[i] = nil
+		}
+	}
+	return blocks, errs
+}
+
+// verify checks that Quorum providers, including the one which served the block, agree on its hash.
+// Providers which fail to answer are skipped, another one is asked instead.
+func (p *Pool) verify(ctx context.Context, served int, block *types.Block) error {
+	agreed := 1
+	for _, i := range p.ranked(block.NumberU64()) {
+		if agreed >= p.config.Quorum {
+			return nil
+		}
+		if i == served {
+			continue
+		}
+
+		start := time.Now()
+		header, err := p.providers[i].Client.HeaderByNumber(ctx, block.Number())
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p.observe(i, start, err)
+		if err != nil {
+			continue
+		}
+		if header.Hash() != block.Hash() {
+			p.disagreements.Add(1)
+			log.Printf("ALERT: providers disagree on block %d: %s serves %s, %s serves %s",
+				block.NumberU64(), p.providers[served].Name, block.Hash(), p.providers[i].Name, header.Hash())
+			return fmt.Errorf("block %d: %w", block.NumberU64(), ErrDisagreement)
+		}
+		agreed++
+	}
+	if agreed < p.config.Quorum {
+		return fmt.Errorf("block %d: only %d of %d providers confirmed its hash", block.NumberU64(), agreed, p.config.Quorum)
+	}
+	return nil
+}
+
+// do runs call against the providers, healthiest first, until one succeeds.
+// Providers known to be behind block `number` are tried last.
+func (p *Pool) do(ctx context.Context, number uint64, call func(i int, c Client) error) error {
+	var errs []error
+	for _, i := range p.ranked(number) {
+		start := time.Now()
+		err := call(i, p.providers[i].Client)
+		if ctx.Err() != nil {
+			// Our own cancellation says nothing about the provider.
+			return ctx.Err()
+		}
+		p.observe(i, start, err)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.providers[i].Name, err))
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("no provider configured")
+	}
+	return fmt.Errorf("all providers failed: %w", errors.Join(errs...))
+}
+
+// ranked returns the providers by decreasing health: those up to date with block `number` come
+// first, ordered by latency with a penalty for errors.
+func (p *Pool) ranked(number uint64) []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	order := make([]int, len(p.health))
+	for i := range order {
+		order[i] = i
+	}
+	behind := func(h *Health) bool {
+		return h.Stale || (h.Head > 0 && h.Head < number)
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		ha, hb := &p.health[a], &p.health[b]
+		if behind(ha) != behind(hb) {
+			if behind(ha) {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Compare(p.score(ha), p.score(hb))
+	})
+	return order
+}
+
+func (p *Pool) score(h *Health) time.Duration {
+	return h.Latency + time.Duration(h.ErrorRate*float64(p.config.ErrorPenalty))
+}
+
+func (p *Pool) observe(i int, start time.Time, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := &p.health[i]
+	h.Latency += time.Duration(smoothing * float64(time.Since(start)-h.Latency))
+	failed := 0.0
+	if err != nil {
+		failed = 1
+	}
+	h.ErrorRate += smoothing * (failed - h.ErrorRate)
+}
+
+// penalize counts a failure of provider i which was not the result of a request of its own.
+func (p *Pool) penalize(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := &p.health[i]
+	h.ErrorRate += smoothing * (1 - h.ErrorRate)
+}
+
+// observeHead records the head served by provider i, and updates which providers are stale.
+func (p *Pool) observeHead(i int, head uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.health[i].Head = head
+
+	var highest uint64
+	for _, h := range p.health {
+		highest = max(highest, h.Head)
+	}
+	for j := range p.health {
+		h := &p.health[j]
+		// A provider which never answered is not stale, its errors already rank it down.
+		stale := h.Head > 0 && h.Head+p.config.MaxHeadLag < highest
+		if stale != h.Stale {
+			if stale {
+				log.Printf("Provider %s is %d blocks behind, demoted", h.Name, highest-h.Head)
+			} else {
+				log.Printf("Provider %s caught up", h.Name)
+			}
+			h.Stale = stale
+		}
+	}
+}
+
+// blockNumber returns the number of a block request, 0 for tags such as latest.
+func blockNumber(number *big.Int) uint64 {
+	if number == nil || number.Sign() < 0 {
+		return 0
+	}
+	return number.Uint64()
+}
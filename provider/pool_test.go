@@ -0,0 +1,163 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeClient serves a fixed head, or fails every request when err is set.
+// Clients with a different fork serve different blocks at the same height.
+type fakeClient struct {
+	head  uint64
+	err   error
+	delay time.Duration
+	fork  string
+	calls atomic.Int64
+}
+
+func (f *fakeClient) header(number *big.Int) *types.Header {
+	return &types.Header{Number: number, Extra: []byte(f.fork)}
+}
+
+func (f *fakeClient) answer() error {
+	f.calls.Add(1)
+	time.Sleep(f.delay)
+	return f.err
+}
+
+func (f *fakeClient) BlockNumber(context.Context) (uint64, error) {
+	return f.head, f.answer()
+}
+
+func (f *fakeClient) BlockByNumber(_ context.Context, number *big.Int) (*types.Block, error) {
+	if err := f.answer(); err != nil {
+		return nil, err
+	}
+	return types.NewBlockWithHeader(f.header(number)), nil
+}
+
+func (f *fakeClient) HeaderByNumber(_ context.Context, number *big.Int) (*types.Header, error) {
+	return f.header(number), f.answer()
+}
+
+func (f *fakeClient) BlockReceipts(context.Context, rpc.BlockNumberOrHash) ([]*types.Receipt, error) {
+	return nil, f.answer()
+}
+
+func (f *fakeClient) BlocksByNumber(_ context.Context, numbers []uint64) ([]*types.Block, []error) {
+	err := f.answer()
+	blocks, errs := make([]*types.Block, len(numbers)), make([]error, len(numbers))
+	for i, n := range numbers {
+		if errs[i] = err; err == nil {
+			blocks[i] = types.NewBlockWithHeader(f.header(new(big.Int).SetUint64(n)))
+		}
+	}
+	return blocks, errs
+}
+
+func TestPool_FailsOver(t *testing.T) {
+	down := &fakeClient{err: errors.New("503 service unavailable")}
+	up := &fakeClient{head: 100, delay: time.Millisecond}
+	pool := NewFromConfig(&Config{MaxHeadLag: 5}, []Provider{{"down", down}, {"up", up}})
+
+	for i := 0; i < 10; i++ {
+		block, err := pool.BlockByNumber(context.Background(), big.NewInt(42))
+		if err != nil {
+			t.Fatalf("expected the request to fail over, got %v", err)
+		}
+		if block.NumberU64() != 42 {
+			t.Fatalf("expected block 42, got %d", block.NumberU64())
+		}
+	}
+	// Once its error rate is known, the failing provider is no longer tried first.
+	if calls := down.calls.Load(); calls != 1 {
+		t.Errorf("expected the failing provider to be tried once, got %d calls", calls)
+	}
+
+	blocks, errs := pool.BlocksByNumber(context.Background(), []uint64{1, 2})
+	if errs[0] != nil || errs[1] != nil || blocks[1].NumberU64() != 2 {
+		t.Errorf("unexpected batch result %v %v", blocks, errs)
+	}
+
+	up.err = errors.New("timeout")
+	if _, err := pool.BlockNumber(context.Background()); err == nil {
+		t.Error("expected an error when every provider fails")
+	}
+}
+
+func TestPool_DemotesStaleProvider(t *testing.T) {
+	// The lagging provider answers faster, it would be preferred if heads were not tracked.
+	lagging := &fakeClient{head: 90}
+	synced := &fakeClient{head: 100, delay: 2 * time.Millisecond}
+	pool := NewFromConfig(&Config{MaxHeadLag: 5, ProbeInterval: 10 * time.Millisecond}, []Provider{{"lagging", lagging}, {"synced", synced}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pool.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !pool.Health()[0].Stale {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the lagging provider to be demoted: %+v", pool.Health())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	head, err := pool.BlockNumber(context.Background())
+	if err != nil || head != 100 {
+		t.Errorf("expected head 100 from the synced provider, got %d (%v)", head, err)
+	}
+
+	// It is promoted again once it catches up.
+	pool.observeHead(0, 101)
+	if h := pool.Health(); h[0].Stale || h[1].Stale {
+		t.Errorf("expected no stale provider, got %+v", h)
+	}
+}
+
+func TestPool_Quorum(t *testing.T) {
+	a, b, c := &fakeClient{}, &fakeClient{delay: time.Millisecond}, &fakeClient{delay: 2 * time.Millisecond}
+	pool := NewFromConfig(&Config{Quorum: 2}, []Provider{{"a", a}, {"b", b}, {"c", c}})
+	ctx := context.Background()
+
+	if _, err := pool.BlockByNumber(ctx, big.NewInt(10)); err != nil {
+		t.Fatalf("expected agreeing providers to reach the quorum, got %v", err)
+	}
+
+	// A provider which does not answer is replaced by the next one.
+	b.err = errors.New("timeout")
+	if _, err := pool.BlockByNumber(ctx, big.NewInt(11)); err != nil {
+		t.Fatalf("expected the quorum to be reached without b, got %v", err)
+	}
+
+	// A provider serving another block holds it, in batches too.
+	b.err, c.fork = nil, "lie"
+	pool.config.Quorum = 3
+	if _, err := pool.BlockByNumber(ctx, big.NewInt(12)); !errors.Is(err, ErrDisagreement) {
+		t.Fatalf("expected a disagreement, got %v", err)
+	}
+	blocks, errs := pool.BlocksByNumber(ctx, []uint64{13, 14})
+	if blocks[0] != nil || !errors.Is(errs[0], ErrDisagreement) || !errors.Is(errs[1], ErrDisagreement) {
+		t.Errorf("expected the batch to be held, got %v %v", blocks, errs)
+	}
+	if n := pool.Disagreements(); n != 3 {
+		t.Errorf("expected 3 disagreements, got %d", n)
+	}
+
+	// Not enough providers left to reach the quorum.
+	c.fork, c.err = "", errors.New("timeout")
+	if _, err := pool.BlockByNumber(ctx, big.NewInt(15)); err == nil {
+		t.Error("expected an error when the quorum cannot be reached")
+	}
+}
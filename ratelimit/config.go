@@ -0,0 +1,27 @@
+package ratelimit
+
+import "time"
+
+type Config struct {
+	// ComputeUnitsPerSecond is the sustained budget shared by every request going through the limiter.
+	ComputeUnitsPerSecond float64
+	// Burst is the number of compute units that can be spent at once. Defaults to one second of budget.
+	Burst float64
+	// Costs overrides the compute units of JSON-RPC methods, see DefaultCosts.
+	Costs map[string]float64
+	// DefaultCost is charged for the methods missing from Costs and DefaultCosts. Defaults to 20.
+	DefaultCost float64
+	// ThrottlePause is how long requests are held after a 429 without a Retry-After header. Defaults to 1s.
+	ThrottlePause time.Duration
+}
+
+// DefaultCosts are the compute units billed by Alchemy for the methods the indexer uses.
+var DefaultCosts = map[string]float64{
+	"eth_blockNumber":        10,
+	"eth_getBlockByNumber":   16,
+	"eth_getBlockByHash":     16,
+	"eth_getBlockReceipts":   500,
+	"debug_traceBlockByHash": 170,
+	"trace_block":            24,
+	"eth_subscribe":          10,
+}
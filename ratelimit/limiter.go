@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Priority of a request, see WithPriority.
+type Priority int
+
+const (
+	// High is the default priority: live head processing.
+	High Priority = iota
+	// Low requests, such as backfill, only get the budget left over by High ones.
+	Low
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose requests are served with the given priority.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// Limiter is a token bucket of compute units. Low priority requests wait as long as high
+// priority ones are waiting, and all requests are held while the provider asked to back off.
+type Limiter struct {
+	config      *Config
+	mu          sync.Mutex
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	highWaiting int
+}
+
+func NewFromConfig(cfg *Config) *Limiter {
+	if cfg.Burst == 0 {
+		cfg.Burst = cfg.ComputeUnitsPerSecond
+	}
+	if cfg.DefaultCost == 0 {
+		cfg.DefaultCost = 20
+	}
+	if cfg.ThrottlePause == 0 {
+		cfg.ThrottlePause = time.Second
+	}
+	return &Limiter{config: cfg, tokens: cfg.Burst, last: time.Now()}
+}
+
+// Cost returns the compute units of a JSON-RPC method.
+func (l *Limiter) Cost(method string) float64 {
+	if cost, ok := l.config.Costs[method]; ok {
+		return cost
+	}
+	if cost, ok := DefaultCosts[method]; ok {
+		return cost
+	}
+	return l.config.DefaultCost
+}
+
+// Wait blocks until cost compute units are available for the priority of ctx, or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, cost float64) error {
+	high := priorityOf(ctx) == High
+	if high {
+		l.mu.Lock()
+		l.highWaiting++
+		l.mu.Unlock()
+		defer func() {
+			l.mu.Lock()
+			l.highWaiting--
+			l.mu.Unlock()
+		}()
+	}
+
+	for {
+		wait := l.reserve(cost, high)
+		if wait == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// reserve takes cost from the bucket, or returns how long to wait before trying again.
+func (l *Limiter) reserve(cost float64, high bool) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	l.tokens = math.Min(l.config.Burst, l.tokens+now.Sub(l.last).Seconds()*l.config.ComputeUnitsPerSecond)
+	l.last = now
+
+	if !high && l.highWaiting > 0 {
+		return 10 * time.Millisecond
+	}
+	// A request costing more than the burst would never fit: it goes once the bucket is full, and
+	// leaves it in debt so that the next requests make up for its full cost.
+	needed := math.Min(cost, l.config.Burst)
+	if l.tokens >= needed {
+		l.tokens -= cost
+		return 0
+	}
+	missing := (needed - l.tokens) / l.config.ComputeUnitsPerSecond
+	return time.Duration(missing * float64(time.Second))
+}
+
+// Pause holds every request for d, e.g. when the provider answers with a Retry-After header.
+func (l *Limiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
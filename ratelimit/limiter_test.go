@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Wait(t *testing.T) {
+	l := NewFromConfig(&Config{ComputeUnitsPerSecond: 1000, Burst: 10})
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		if err := l.Wait(context.Background(), 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first request uses the burst, the next ten wait 10ms each.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected the requests to be spread over 100ms, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx, 10); err == nil {
+		t.Error("expected Wait to return when the context is done")
+	}
+}
+
+func TestLimiter_CostAboveBurst(t *testing.T) {
+	l := NewFromConfig(&Config{ComputeUnitsPerSecond: 1000, Burst: 100})
+	start := time.Now()
+	// e.g. eth_getBlockReceipts on a plan whose burst is smaller than its cost.
+	if err := l.Wait(context.Background(), 300); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected the first request to go with a full bucket, took %s", elapsed)
+	}
+	// The bucket is 200 in debt: the next request waits for 210 units.
+	if err := l.Wait(context.Background(), 10); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("expected the full cost to be charged, the next request only waited %s", elapsed)
+	}
+}
+
+func TestLimiter_Priority(t *testing.T) {
+	l := NewFromConfig(&Config{ComputeUnitsPerSecond: 100, Burst: 10})
+	_ = l.Wait(context.Background(), 10)
+
+	order := make(chan Priority, 2)
+	go func() {
+		_ = l.Wait(WithPriority(context.Background(), Low), 10)
+		order <- Low
+	}()
+	time.Sleep(5 * time.Millisecond)
+	go func() {
+		_ = l.Wait(context.Background(), 10)
+		order <- High
+	}()
+
+	if first := <-order; first != High {
+		t.Error("expected the high priority request to be served first")
+	}
+	<-order
+}
+
+func TestTransport(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+
+	l := NewFromConfig(&Config{ComputeUnitsPerSecond: 1000})
+	client := &http.Client{Transport: l.Transport(http.DefaultTransport)}
+	post := func() *http.Response {
+		resp, err := client.Post(server.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	if resp := post(); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the throttled response to be returned, got %d", resp.StatusCode)
+	}
+	start := time.Now()
+	if resp := post(); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the retry to succeed, got %d", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("expected the retry to wait for Retry-After, took %s", elapsed)
+	}
+
+	tr := &transport{limiter: l}
+	batch := `[{"method":"eth_getBlockByNumber"},{"method":"eth_getBlockByNumber"},{"method":"eth_getBlockReceipts"}]`
+	if cost := tr.cost([]byte(batch)); cost != 532 {
+		t.Errorf("expected a batch to cost the sum of its calls, got %v", cost)
+	}
+	if cost := tr.cost([]byte(`{"method":"eth_chainId"}`)); cost != 20 {
+		t.Errorf("expected unknown methods to cost the default, got %v", cost)
+	}
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Transport charges each JSON-RPC request, batches included, to the limiter before sending it,
+// and pauses the limiter when the provider throttles.
+func (l *Limiter) Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{limiter: l, base: base}
+}
+
+type transport struct {
+	limiter *Limiter
+	base    http.RoundTripper
+}
+
+type rpcCall struct {
+	Method string `json:"method"`
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		if err := t.limiter.Wait(req.Context(), t.cost(body)); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		t.limiter.Pause(t.retryAfter(resp.Header.Get("Retry-After")))
+	}
+	return resp, nil
+}
+
+// cost sums the compute units of a single call or of a batch.
+func (t *transport) cost(body []byte) float64 {
+	var calls []rpcCall
+	if err := json.Unmarshal(body, &calls); err != nil {
+		var call rpcCall
+		if err := json.Unmarshal(body, &call); err != nil {
+			return t.limiter.config.DefaultCost
+		}
+		calls = []rpcCall{call}
+	}
+
+	var cost float64
+	for _, call := range calls {
+		cost += t.limiter.Cost(call.Method)
+	}
+	return cost
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or as an HTTP date.
+func (t *transport) retryAfter(header string) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+	return t.limiter.config.ThrottlePause
+}
@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Config struct {
+	// ComputeUnitsPerSecond is the throughput of the provider plan of the deployment, shared by all chains.
+	ComputeUnitsPerSecond float64 `json:"computeUnitsPerSecond"`
+	Chains                []Chain `json:"chains"`
+}
+
+// Chain describes one pipeline: where blocks come from and where messages go.
+type Chain struct {
+	Name    string `json:"name"`
+	ChainID uint64 `json:"chainId"`
+	// Endpoints may reference environment variables, e.g. ${ALCHEMY_API_KEY}.
+	RPCURL string `json:"rpcUrl"`
+	// FallbackRPCURLs are other providers of the same chain, requests go to the healthiest one.
+	FallbackRPCURLs []string `json:"fallbackRpcUrls"`
+	WSURL           string   `json:"wsUrl"`
+	PollInterval    Duration `json:"pollInterval"`
+	// BlockTime is the average time between blocks, to estimate the compute units spent on the chain.
+	// Defaults to PollInterval.
+	BlockTime      Duration `json:"blockTime"`
+	Confirmations  uint64   `json:"confirmations"`
+	CheckpointFile string   `json:"checkpointFile"`
+	Topic          string   `json:"topic"`
+	// BatchSize is the number of blocks fetched per JSON-RPC batch, within the provider's batch limit.
+	BatchSize int `json:"batchSize"`
+	// Quorum is the number of providers which must agree on each block hash, see provider.Config.
+	Quorum int `json:"quorum"`
+	// L2 marks OP-stack and Arbitrum chains, whose system transactions are skipped when decoding blocks.
+	L2 bool `json:"l2"`
+	// VerifyBodies checks blocks and receipts against the header roots, see provider.Config.
+	// It cannot be set on L2 chains: without their system transactions, no body matches its header.
+	VerifyBodies bool `json:"verifyBodies"`
+	// FetchReceipts fetches the receipts of every block, for token transfers, fees and rewards.
+	// It is by far the most expensive call of a block.
+	FetchReceipts bool `json:"fetchReceipts"`
+	// WatchMempool publishes pending events for the mempool transactions of watched addresses. Needs WSURL.
+	WatchMempool bool `json:"watchMempool"`
+}
+
+// Duration is a time.Duration written as a string such as "1s" or "250ms".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"deblockTest/ratelimit"
+)
+
+// Load reads the chain registry and expands the environment variables of the endpoints.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(cfg.Chains) == 0 {
+		return nil, fmt.Errorf("%s: no chain configured", path)
+	}
+	if cfg.ComputeUnitsPerSecond <= 0 {
+		return nil, fmt.Errorf("%s: no computeUnitsPerSecond", path)
+	}
+
+	chainIDs := make(map[uint64]bool, len(cfg.Chains))
+	checkpoints := make(map[string]bool, len(cfg.Chains))
+	for i := range cfg.Chains {
+		c := &cfg.Chains[i]
+		c.RPCURL = os.ExpandEnv(c.RPCURL)
+		c.WSURL = os.ExpandEnv(c.WSURL)
+		for j := range c.FallbackRPCURLs {
+			c.FallbackRPCURLs[j] = os.ExpandEnv(c.FallbackRPCURLs[j])
+		}
+
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("%s: chain %d has no name", path, i)
+		case c.ChainID == 0:
+			return nil, fmt.Errorf("%s: chain %s has no chainId", path, c.Name)
+		case c.RPCURL == "":
+			return nil, fmt.Errorf("%s: chain %s has no rpcUrl", path, c.Name)
+		case c.PollInterval <= 0:
+			return nil, fmt.Errorf("%s: chain %s has no pollInterval", path, c.Name)
+		case c.CheckpointFile == "":
+			return nil, fmt.Errorf("%s: chain %s has no checkpointFile", path, c.Name)
+		case c.Topic == "":
+			return nil, fmt.Errorf("%s: chain %s has no topic", path, c.Name)
+		case c.Quorum > 1+len(c.FallbackRPCURLs):
+			return nil, fmt.Errorf("%s: chain %s needs %d providers for its quorum", path, c.Name, c.Quorum)
+		case c.VerifyBodies && c.L2:
+			return nil, fmt.Errorf("%s: chain %s cannot verify the bodies of an L2 chain", path, c.Name)
+		case c.WatchMempool && c.WSURL == "":
+			return nil, fmt.Errorf("%s: chain %s needs a wsUrl to watch the mempool", path, c.Name)
+		case chainIDs[c.ChainID]:
+			return nil, fmt.Errorf("%s: chainId %d is configured twice", path, c.ChainID)
+		case checkpoints[c.CheckpointFile]:
+			// Two pipelines sharing a checkpoint would overwrite each other's progress.
+			return nil, fmt.Errorf("%s: checkpointFile %s is shared by several chains", path, c.CheckpointFile)
+		}
+		chainIDs[c.ChainID] = true
+		checkpoints[c.CheckpointFile] = true
+	}
+
+	// Over budget, every chain would fall further and further behind its head.
+	if spend := cfg.ComputeUnits(); spend > cfg.ComputeUnitsPerSecond {
+		return nil, fmt.Errorf("%s: the chains need about %.0f compute units per second, above the computeUnitsPerSecond of %.0f",
+			path, spend, cfg.ComputeUnitsPerSecond)
+	}
+	return &cfg, nil
+}
+
+// ComputeUnits estimates the compute units per second spent by the live pipelines of every chain.
+func (c *Config) ComputeUnits() float64 {
+	var total float64
+	for i := range c.Chains {
+		total += c.Chains[i].ComputeUnits()
+	}
+	return total
+}
+
+// ComputeUnits estimates the compute units per second spent by the live pipeline of the chain at
+// head, with the default costs of the limiter: the head polls, and the calls made for each block.
+func (c *Chain) ComputeUnits() float64 {
+	costs := ratelimit.DefaultCosts
+	// The header tracked for reorgs, the body, and the headers of the other providers of the quorum.
+	perBlock := (2 + float64(max(c.Quorum-1, 0))) * costs["eth_getBlockByNumber"]
+	if c.FetchReceipts {
+		perBlock += costs["eth_getBlockReceipts"]
+	}
+
+	blockTime := c.BlockTime
+	if blockTime <= 0 {
+		blockTime = c.PollInterval
+	}
+	return perBlock/time.Duration(blockTime).Seconds() + costs["eth_blockNumber"]/time.Duration(c.PollInterval).Seconds()
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRegistry(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chains.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write registry: %v", err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv("TEST_API_KEY", "secret")
+	path := writeRegistry(t, `{"computeUnitsPerSecond": 330, "chains": [
+		{"name": "ethereum", "chainId": 1, "rpcUrl": "https://rpc/${TEST_API_KEY}", "pollInterval": "1s", "checkpointFile": "eth.txt", "topic": "eth"},
+		{"name": "base", "chainId": 8453, "rpcUrl": "https://base", "fallbackRpcUrls": ["https://backup/${TEST_API_KEY}"], "wsUrl": "wss://base/$TEST_API_KEY", "pollInterval": "250ms", "confirmations": 10, "checkpointFile": "base.txt", "topic": "base"}
+	]}`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load registry: %v", err)
+	}
+	if len(cfg.Chains) != 2 {
+		t.Fatalf("Expected 2 chains, got %d", len(cfg.Chains))
+	}
+
+	eth, base := cfg.Chains[0], cfg.Chains[1]
+	if eth.RPCURL != "https://rpc/secret" || base.WSURL != "wss://base/secret" {
+		t.Errorf("Expected environment variables to be expanded, got %s and %s", eth.RPCURL, base.WSURL)
+	}
+	if len(base.FallbackRPCURLs) != 1 || base.FallbackRPCURLs[0] != "https://backup/secret" {
+		t.Errorf("Expected fallback endpoints to be expanded, got %v", base.FallbackRPCURLs)
+	}
+	if time.Duration(base.PollInterval) != 250*time.Millisecond || base.Confirmations != 10 || base.ChainID != 8453 {
+		t.Errorf("Unexpected base chain: %+v", base)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	testCases := map[string]string{
+		"no chain":            `{"chains": []}`,
+		"missing rpc":         `{"chains": [{"name": "eth", "chainId": 1, "checkpointFile": "eth.txt", "topic": "eth"}]}`,
+		"no poll interval":    `{"chains": [{"name": "eth", "chainId": 1, "rpcUrl": "x", "checkpointFile": "eth.txt", "topic": "eth"}]}`,
+		"zero poll interval":  `{"chains": [{"name": "eth", "chainId": 1, "rpcUrl": "x", "pollInterval": "0s", "checkpointFile": "eth.txt", "topic": "eth"}]}`,
+		"bad duration":        `{"chains": [{"name": "eth", "chainId": 1, "rpcUrl": "x", "pollInterval": "often", "checkpointFile": "eth.txt", "topic": "eth"}]}`,
+		"duplicate chain":     `{"chains": [{"name": "a", "chainId": 1, "rpcUrl": "x", "checkpointFile": "a.txt", "topic": "a"}, {"name": "b", "chainId": 1, "rpcUrl": "x", "checkpointFile": "b.txt", "topic": "b"}]}`,
+		"shared checkpoint":   `{"chains": [{"name": "a", "chainId": 1, "rpcUrl": "x", "checkpointFile": "c.txt", "topic": "a"}, {"name": "b", "chainId": 2, "rpcUrl": "x", "checkpointFile": "c.txt", "topic": "b"}]}`,
+		"quorum too large":    `{"chains": [{"name": "eth", "chainId": 1, "rpcUrl": "x", "fallbackRpcUrls": ["y"], "quorum": 3, "checkpointFile": "eth.txt", "topic": "eth"}]}`,
+		"verified L2 bodies":  `{"computeUnitsPerSecond": 330, "chains": [{"name": "base", "chainId": 8453, "rpcUrl": "x", "pollInterval": "1s", "l2": true, "verifyBodies": true, "checkpointFile": "base.txt", "topic": "base"}]}`,
+		"mempool without ws":  `{"chains": [{"name": "eth", "chainId": 1, "rpcUrl": "x", "watchMempool": true, "checkpointFile": "eth.txt", "topic": "eth"}]}`,
+		"not a registry file": `chains:`,
+		"no budget":           `{"chains": [{"name": "eth", "chainId": 1, "rpcUrl": "x", "pollInterval": "1s", "checkpointFile": "eth.txt", "topic": "eth"}]}`,
+		"over budget":         `{"computeUnitsPerSecond": 330, "chains": [{"name": "arbitrum", "chainId": 42161, "rpcUrl": "x", "pollInterval": "250ms", "fetchReceipts": true, "checkpointFile": "arb.txt", "topic": "arb"}]}`,
+	}
+
+	for name, content := range testCases {
+		if _, err := Load(writeRegistry(t, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestChain_ComputeUnits(t *testing.T) {
+	c := Chain{PollInterval: Duration(time.Second), BlockTime: Duration(12 * time.Second), FetchReceipts: true, Quorum: 2}
+	// 10 for the head poll every second, and (16 * 3 + 500) every 12 seconds.
+	if got := c.ComputeUnits(); got < 55.6 || got > 55.7 {
+		t.Errorf("expected about 55.67 compute units per second, got %v", got)
+	}
+
+	// Without a block time, a block is expected at every poll.
+	c = Chain{PollInterval: Duration(250 * time.Millisecond)}
+	if got := c.ComputeUnits(); got != 168 {
+		t.Errorf("expected 168 compute units per second, got %v", got)
+	}
+}
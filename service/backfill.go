@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"deblockTest/ratelimit"
+)
+
+// Backfill re-processes the blocks from `from` to `to` and returns once all of them are published.
+// Progress is saved to the service State, which must be a checkpoint of its own: an interrupted
+// backfill resumes where it stopped, and it can run next to live ingestion without moving its checkpoint.
+// The checkpoint is reset once the range is done, so that running it again re-indexes the whole range.
+// Like Run, it must be called after Setup.
+func (s *Service) Backfill(ctx context.Context, from, to uint64) error {
+	if from > to {
+		return fmt.Errorf("invalid range: %d > %d", from, to)
+	}
+	// Blocks above the head could only be retried until they are mined.
+	head, err := s.headNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("get head: %w", err)
+	}
+	if to > head {
+		return fmt.Errorf("invalid range: block %d is above the head %d", to, head)
+	}
+	if !s.waitReady(ctx) {
+		return ctx.Err()
+	}
+	go s.mergeRetries()
+
+	// LoadCheckpoint returns 0 without checkpoint: a backfill from block 0 then starts over, and only
+	// re-publishes block 0 if it had stopped right after it. A checkpoint at `to` is a finished range.
+	start := from
+	if checkpoint := s.state.LoadCheckpoint(); checkpoint != 0 && checkpoint >= from && checkpoint < to {
+		start = checkpoint + 1
+		log.Printf("Resuming backfill from block %d", start)
+	}
+
+	event := s.finalEvent()
+	acked := make(map[uint64]bool)
+	next, cursor := start, start
+	for next <= to {
+		// Stop offering jobs once the whole range is queued.
+		var jobs chan<- blockJob
+		if cursor <= to {
+			jobs = s.blocks
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case jobs <- blockJob{number: cursor, event: event, priority: ratelimit.Low}:
+			cursor++
+		case ack := <-s.ackChan:
+			// Acks arrive out of order, progress only moves over a contiguous range.
+			acked[ack.number] = true
+			for acked[next] {
+				delete(acked, next)
+				next++
+				s.processedCount++
+				if next%100 == 0 {
+					log.Printf("Backfill at block %d/%d", next-1, to)
+					_ = s.state.SaveCheckpoint(next - 1)
+				}
+			}
+		}
+	}
+
+	log.Printf("Backfill of blocks %d to %d done", from, to)
+	return s.state.SaveCheckpoint(0)
+}
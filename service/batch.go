@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"deblockTest/ratelimit"
+)
+
+// BlockBatcher is implemented by clients able to fetch several blocks in one round trip, such as batch.Client.
+type BlockBatcher interface {
+	BlocksByNumber(ctx context.Context, numbers []uint64) ([]*types.Block, []error)
+}
+
+// batchBlocks groups the queued jobs by up to BatchSize, fetches their blocks in a single request
+// and fans them out to the workers. A block missing from the batch is left to the worker to fetch.
+func (s *Service) batchBlocks(ctx context.Context, batcher BlockBatcher, jobs <-chan blockJob, fetched chan<- blockJob) {
+	batch := make([]blockJob, 0, s.config.BatchSize)
+	numbers := make([]uint64, 0, s.config.BatchSize)
+	for job := range jobs {
+		// Take whatever is already queued, without waiting for a full batch.
+		batch = append(batch[:0], job)
+	drain:
+		for len(batch) < s.config.BatchSize {
+			select {
+			case job := <-jobs:
+				batch = append(batch, job)
+			default:
+				break drain
+			}
+		}
+
+		numbers = numbers[:0]
+		priority := ratelimit.Low
+		for _, job := range batch {
+			numbers = append(numbers, job.number)
+			priority = min(priority, job.priority)
+		}
+		blocks, errs := batcher.BlocksByNumber(ratelimit.WithPriority(ctx, priority), numbers)
+		for i, job := range batch {
+			if errs[i] != nil {
+				log.Printf("Failed to fetch block %d in batch: %v", job.number, errs[i])
+			} else {
+				job.block = blocks[i]
+			}
+			fetched <- job
+		}
+	}
+}
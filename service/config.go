@@ -2,8 +2,36 @@ package service
 
 import "time"
 
+// Head tags bounding ingestion, see Config.HeadTag.
+const (
+	HeadLatest    = "latest"
+	HeadSafe      = "safe"
+	HeadFinalized = "finalized"
+)
+
 type Config struct {
+	// ChainID is the id of the followed chain, used to recover senders. Defaults to 1 (mainnet).
+	ChainID        uint64
 	WorkerCount    int
 	PollInterval   time.Duration
 	CheckpointFile string
+	// ReorgDepth is the number of recent block hashes kept to detect chain reorganizations.
+	// 0 disables reorg detection.
+	ReorgDepth uint64
+	// Confirmations is the number of blocks a block must be behind the head before being published.
+	Confirmations uint64
+	// EmitSeen enables two-phase mode: a "seen" event at head, then a "confirmed" event at Confirmations depth.
+	EmitSeen bool
+	// HeadTag selects the upper bound of ingestion: HeadLatest (default), HeadSafe or HeadFinalized.
+	HeadTag string
+	// ResubscribeInterval is how long the service polls before retrying a dropped newHeads subscription.
+	ResubscribeInterval time.Duration
+	// FetchReceipts fetches the receipts of every block to detect token and NFT transfers,
+	// to report the execution status and fees of transactions, and the rewards of watched coinbases.
+	FetchReceipts bool
+	// BatchSize groups up to BatchSize queued blocks in one JSON-RPC batch request, when the client
+	// implements BlockBatcher. 0 or 1 fetches blocks one by one.
+	BatchSize int
+	// SkipReverted drops the messages of reverted transactions instead of flagging them. Needs FetchReceipts.
+	SkipReverted bool
 }
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// HeadSource notifies the service each time the upper bound of ingestion moves.
+// Heads may be skipped: the service always enqueues every block up to the last notified head,
+// so any gap is filled on the next notification.
+type HeadSource interface {
+	Heads(ctx context.Context) <-chan uint64
+}
+
+// HeadSubscriber is implemented by clients connected over WebSocket, such as ethclient.Client.
+type HeadSubscriber interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// pollingHeadSource polls the head every interval.
+type pollingHeadSource struct {
+	fetch    func(ctx context.Context) (uint64, error)
+	interval time.Duration
+}
+
+func (p *pollingHeadSource) Heads(ctx context.Context) <-chan uint64 {
+	heads := make(chan uint64)
+	go func() {
+		defer close(heads)
+		for {
+			wait := p.interval
+			head, err := p.fetch(ctx)
+			if err != nil {
+				log.Printf("Failed to get latest block: %v", err)
+				wait = 2 * time.Second
+			} else {
+				select {
+				case heads <- head:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+	return heads
+}
+
+// subscriptionHeadSource follows eth_subscribe newHeads. While the subscription is down it
+// falls back to polling, and tries to resubscribe every retryInterval.
+type subscriptionHeadSource struct {
+	client        HeadSubscriber
+	fallback      HeadSource
+	retryInterval time.Duration
+	// resolve maps a new head to the ingestion bound when it is not the latest block (safe/finalized tags).
+	resolve func(ctx context.Context) (uint64, error)
+}
+
+func (s *subscriptionHeadSource) Heads(ctx context.Context) <-chan uint64 {
+	heads := make(chan uint64)
+	go func() {
+		defer close(heads)
+		for ctx.Err() == nil {
+			headers := make(chan *types.Header, 16)
+			sub, err := s.client.SubscribeNewHead(ctx, headers)
+			if err != nil {
+				log.Printf("newHeads subscription failed, polling for %s: %v", s.retryInterval, err)
+				s.poll(ctx, heads)
+				continue
+			}
+
+			err = s.forward(ctx, sub, headers, heads)
+			sub.Unsubscribe()
+			if err != nil {
+				log.Printf("newHeads subscription dropped, polling for %s: %v", s.retryInterval, err)
+				s.poll(ctx, heads)
+			}
+		}
+	}()
+	return heads
+}
+
+// forward relays the subscription until it fails or ctx is done.
+func (s *subscriptionHeadSource) forward(ctx context.Context, sub ethereum.Subscription, headers <-chan *types.Header, heads chan<- uint64) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-sub.Err():
+			// Relay the heads received before the failure.
+			for {
+				select {
+				case header := <-headers:
+					s.relay(ctx, header, heads)
+				default:
+					return err
+				}
+			}
+		case header := <-headers:
+			s.relay(ctx, header, heads)
+		}
+	}
+}
+
+func (s *subscriptionHeadSource) relay(ctx context.Context, header *types.Header, heads chan<- uint64) {
+	head := header.Number.Uint64()
+	if s.resolve != nil {
+		var err error
+		if head, err = s.resolve(ctx); err != nil {
+			log.Printf("Failed to get latest block: %v", err)
+			return
+		}
+	}
+	select {
+	case heads <- head:
+	case <-ctx.Done():
+	}
+}
+
+func (s *subscriptionHeadSource) poll(ctx context.Context, heads chan<- uint64) {
+	pollCtx, cancel := context.WithTimeout(ctx, s.retryInterval)
+	defer cancel()
+	for head := range s.fallback.Heads(pollCtx) {
+		select {
+		case heads <- head:
+		case <-ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// flakySubscriber delivers the given heads on each subscription, then drops it.
+type flakySubscriber struct {
+	subscriptions [][]uint64
+}
+
+func (f *flakySubscriber) SubscribeNewHead(_ context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	if len(f.subscriptions) == 0 {
+		return nil, errors.New("connection refused")
+	}
+	heads := f.subscriptions[0]
+	f.subscriptions = f.subscriptions[1:]
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		for _, n := range heads {
+			select {
+			case ch <- &types.Header{Number: new(big.Int).SetUint64(n)}:
+			case <-quit:
+				return nil
+			}
+		}
+		return errors.New("websocket: close 1006")
+	}), nil
+}
+
+func TestSubscriptionHeadSource_FallsBackToPolling(t *testing.T) {
+	polled := uint64(7)
+	source := &subscriptionHeadSource{
+		client: &flakySubscriber{subscriptions: [][]uint64{{5, 6}, {8}}},
+		fallback: &pollingHeadSource{
+			fetch:    func(context.Context) (uint64, error) { return polled, nil },
+			interval: time.Hour,
+		},
+		retryInterval: 20 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	heads := source.Heads(ctx)
+
+	for _, expected := range []uint64{5, 6, 7, 8, 7} {
+		select {
+		case head := <-heads:
+			if head != expected {
+				t.Fatalf("expected head %d, got %d", expected, head)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for head %d", expected)
+		}
+	}
+}
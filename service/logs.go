@@ -0,0 +1,77 @@
+package service
+
+import (
+	"log"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"deblockTest/pkg"
+)
+
+var (
+	// transferTopic is the signature of Transfer(address,address,uint256), shared by ERC-20 and ERC-721.
+	transferTopic       = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	transferSingleTopic = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	transferBatchTopic  = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+
+	uint256Array, _   = abi.NewType("uint256[]", "", nil)
+	transferBatchArgs = abi.Arguments{{Type: uint256Array}, {Type: uint256Array}}
+)
+
+// processLogs decodes the token transfer logs involving a watched address.
+func (w *Worker) processLogs(block *types.Block, receipts []*types.Receipt) []pkg.TxMessage {
+	var msgs []pkg.TxMessage
+	for _, receipt := range receipts {
+		for _, l := range receipt.Logs {
+			if len(l.Topics) == 0 {
+				continue
+			}
+			switch {
+			case l.Topics[0] == transferTopic && len(l.Topics) == 3 && len(l.Data) == 32:
+				// ERC-20 indexes from and to, the amount is the only data word.
+				msgs = w.matchLog(msgs, block, l, l.Topics[1], l.Topics[2], pkg.AssetERC20, "", new(big.Int).SetBytes(l.Data))
+			case l.Topics[0] == transferTopic && len(l.Topics) == 4 && len(l.Data) == 0:
+				// ERC-721 also indexes the token id.
+				msgs = w.matchLog(msgs, block, l, l.Topics[1], l.Topics[2], pkg.AssetERC721, l.Topics[3].Big().String(), common.Big1)
+			case l.Topics[0] == transferSingleTopic && len(l.Topics) == 4 && len(l.Data) == 64:
+				id, value := new(big.Int).SetBytes(l.Data[:32]), new(big.Int).SetBytes(l.Data[32:])
+				msgs = w.matchLog(msgs, block, l, l.Topics[2], l.Topics[3], pkg.AssetERC1155, id.String(), value)
+			case l.Topics[0] == transferBatchTopic && len(l.Topics) == 4:
+				values, err := transferBatchArgs.Unpack(l.Data)
+				if err != nil {
+					log.Printf("Failed to decode TransferBatch log %d of block %d: %v", l.Index, block.NumberU64(), err)
+					continue
+				}
+				ids, amounts := values[0].([]*big.Int), values[1].([]*big.Int)
+				for i := 0; i < len(ids) && i < len(amounts); i++ {
+					msgs = w.matchLog(msgs, block, l, l.Topics[2], l.Topics[3], pkg.AssetERC1155, ids[i].String(), amounts[i])
+				}
+			}
+		}
+	}
+	return msgs
+}
+
+func (w *Worker) matchLog(msgs []pkg.TxMessage, block *types.Block, l *types.Log, fromTopic, toTopic common.Hash, assetType, tokenID string, amount *big.Int) []pkg.TxMessage {
+	from := common.BytesToAddress(fromTopic.Bytes())
+	to := common.BytesToAddress(toTopic.Bytes())
+	logIndex := l.Index
+
+	return w.match(msgs, &from, &to, pkg.TxMessage{
+		From:        from.Hex(),
+		To:          to.Hex(),
+		Amount:      amount.String(),
+		Hash:        l.TxHash.Hex(),
+		BlockNumber: block.NumberU64(),
+		BlockHash:   block.Hash().Hex(),
+		Type:        pkg.TypeTransfer,
+		AssetType:   assetType,
+		Token:       l.Address.Hex(),
+		TokenID:     tokenID,
+		LogIndex:    &logIndex,
+	})
+}
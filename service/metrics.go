@@ -0,0 +1,13 @@
+package service
+
+import "sync/atomic"
+
+// Metrics are counters shared by the service and its workers.
+type Metrics struct {
+	// SenderRecoveryFailures counts the transactions whose sender could not be recovered.
+	SenderRecoveryFailures atomic.Uint64
+}
+
+func (s *Service) Metrics() *Metrics {
+	return &s.metrics
+}
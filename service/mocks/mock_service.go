@@ -17,6 +17,7 @@ import (
 
 	common "github.com/ethereum/go-ethereum/common"
 	types "github.com/ethereum/go-ethereum/core/types"
+	rpc "github.com/ethereum/go-ethereum/rpc"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -83,6 +84,45 @@ func (c *MockEthereumBlockGetterBlockByNumberCall) DoAndReturn(f func(context.Co
 	return c
 }
 
+// BlockReceipts mocks base method.
+func (m *MockEthereumBlockGetter) BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BlockReceipts", ctx, blockNrOrHash)
+	ret0, _ := ret[0].([]*types.Receipt)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// BlockReceipts indicates an expected call of BlockReceipts.
+func (mr *MockEthereumBlockGetterMockRecorder) BlockReceipts(ctx, blockNrOrHash any) *MockEthereumBlockGetterBlockReceiptsCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BlockReceipts", reflect.TypeOf((*MockEthereumBlockGetter)(nil).BlockReceipts), ctx, blockNrOrHash)
+	return &MockEthereumBlockGetterBlockReceiptsCall{Call: call}
+}
+
+// MockEthereumBlockGetterBlockReceiptsCall wrap *gomock.Call
+type MockEthereumBlockGetterBlockReceiptsCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *MockEthereumBlockGetterBlockReceiptsCall) Return(arg0 []*types.Receipt, arg1 error) *MockEthereumBlockGetterBlockReceiptsCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *MockEthereumBlockGetterBlockReceiptsCall) Do(f func(context.Context, rpc.BlockNumberOrHash) ([]*types.Receipt, error)) *MockEthereumBlockGetterBlockReceiptsCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *MockEthereumBlockGetterBlockReceiptsCall) DoAndReturn(f func(context.Context, rpc.BlockNumberOrHash) ([]*types.Receipt, error)) *MockEthereumBlockGetterBlockReceiptsCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
 // BlockNumber mocks base method.
 func (m *MockEthereumBlockGetter) BlockNumber(ctx context.Context) (uint64, error) {
 	m.ctrl.T.Helper()
@@ -122,6 +162,45 @@ func (c *MockEthereumBlockGetterBlockNumberCall) DoAndReturn(f func(context.Cont
 	return c
 }
 
+// HeaderByNumber mocks base method.
+func (m *MockEthereumBlockGetter) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HeaderByNumber", ctx, number)
+	ret0, _ := ret[0].(*types.Header)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HeaderByNumber indicates an expected call of HeaderByNumber.
+func (mr *MockEthereumBlockGetterMockRecorder) HeaderByNumber(ctx, number any) *MockEthereumBlockGetterHeaderByNumberCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HeaderByNumber", reflect.TypeOf((*MockEthereumBlockGetter)(nil).HeaderByNumber), ctx, number)
+	return &MockEthereumBlockGetterHeaderByNumberCall{Call: call}
+}
+
+// MockEthereumBlockGetterHeaderByNumberCall wrap *gomock.Call
+type MockEthereumBlockGetterHeaderByNumberCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *MockEthereumBlockGetterHeaderByNumberCall) Return(arg0 *types.Header, arg1 error) *MockEthereumBlockGetterHeaderByNumberCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *MockEthereumBlockGetterHeaderByNumberCall) Do(f func(context.Context, *big.Int) (*types.Header, error)) *MockEthereumBlockGetterHeaderByNumberCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *MockEthereumBlockGetterHeaderByNumberCall) DoAndReturn(f func(context.Context, *big.Int) (*types.Header, error)) *MockEthereumBlockGetterHeaderByNumberCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
 // MockPublisher is a mock of Publisher interface.
 type MockPublisher struct {
 	ctrl     *gomock.Controller
@@ -206,41 +285,41 @@ func (m *MockUserGetter) EXPECT() *MockUserGetterMockRecorder {
 	return m.recorder
 }
 
-// GetUserID mocks base method.
-func (m *MockUserGetter) GetUserID(addr common.Address) (string, bool) {
+// Lookup mocks base method.
+func (m *MockUserGetter) Lookup(addr common.Address) (pkg.AddressRecord, bool) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUserID", addr)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Lookup", addr)
+	ret0, _ := ret[0].(pkg.AddressRecord)
 	ret1, _ := ret[1].(bool)
 	return ret0, ret1
 }
 
-// GetUserID indicates an expected call of GetUserID.
-func (mr *MockUserGetterMockRecorder) GetUserID(addr any) *MockUserGetterGetUserIDCall {
+// Lookup indicates an expected call of Lookup.
+func (mr *MockUserGetterMockRecorder) Lookup(addr any) *MockUserGetterLookupCall {
 	mr.mock.ctrl.T.Helper()
-	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserID", reflect.TypeOf((*MockUserGetter)(nil).GetUserID), addr)
-	return &MockUserGetterGetUserIDCall{Call: call}
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lookup", reflect.TypeOf((*MockUserGetter)(nil).Lookup), addr)
+	return &MockUserGetterLookupCall{Call: call}
 }
 
-// MockUserGetterGetUserIDCall wrap *gomock.Call
-type MockUserGetterGetUserIDCall struct {
+// MockUserGetterLookupCall wrap *gomock.Call
+type MockUserGetterLookupCall struct {
 	*gomock.Call
 }
 
 // Return rewrite *gomock.Call.Return
-func (c *MockUserGetterGetUserIDCall) Return(arg0 string, arg1 bool) *MockUserGetterGetUserIDCall {
+func (c *MockUserGetterLookupCall) Return(arg0 pkg.AddressRecord, arg1 bool) *MockUserGetterLookupCall {
 	c.Call = c.Call.Return(arg0, arg1)
 	return c
 }
 
 // Do rewrite *gomock.Call.Do
-func (c *MockUserGetterGetUserIDCall) Do(f func(common.Address) (string, bool)) *MockUserGetterGetUserIDCall {
+func (c *MockUserGetterLookupCall) Do(f func(common.Address) (pkg.AddressRecord, bool)) *MockUserGetterLookupCall {
 	c.Call = c.Call.Do(f)
 	return c
 }
 
 // DoAndReturn rewrite *gomock.Call.DoAndReturn
-func (c *MockUserGetterGetUserIDCall) DoAndReturn(f func(common.Address) (string, bool)) *MockUserGetterGetUserIDCall {
+func (c *MockUserGetterLookupCall) DoAndReturn(f func(common.Address) (pkg.AddressRecord, bool)) *MockUserGetterLookupCall {
 	c.Call = c.Call.DoAndReturn(f)
 	return c
 }
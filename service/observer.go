@@ -0,0 +1,17 @@
+package service
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// BlockObserver is told about every block processed by the workers, see mempool.Watcher.
+type BlockObserver interface {
+	ObserveBlock(ctx context.Context, block *types.Block)
+}
+
+// SetBlockObserver registers o to be called for every processed block. It must be called before Setup.
+func (s *Service) SetBlockObserver(o BlockObserver) {
+	s.observer = o
+}
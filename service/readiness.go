@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"log"
+)
+
+// Readiness gates the processing of blocks, see kafka.AddressFeed: blocks processed before the
+// address book caught up would miss the transfers of the addresses not loaded yet.
+type Readiness interface {
+	Ready() <-chan struct{}
+}
+
+// WaitFor makes Run and Backfill wait for r before processing any block. It must be called before Setup.
+func (s *Service) WaitFor(r Readiness) {
+	s.readiness = r
+}
+
+// waitReady returns false if ctx is done before the service is ready.
+func (s *Service) waitReady(ctx context.Context) bool {
+	if s.readiness == nil {
+		return true
+	}
+	select {
+	case <-s.readiness.Ready():
+		return true
+	default:
+	}
+
+	log.Printf("Waiting for the address book to catch up")
+	select {
+	case <-s.readiness.Ready():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
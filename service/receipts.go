@@ -0,0 +1,52 @@
+package service
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"deblockTest/pkg"
+)
+
+// applyReceipts attaches the execution outcome of each transaction to its messages,
+// and drops the messages of reverted transactions when SkipReverted is set.
+func (w *Worker) applyReceipts(msgs []pkg.TxMessage, receipts []*types.Receipt) []pkg.TxMessage {
+	byHash := make(map[string]*types.Receipt, len(receipts))
+	for _, r := range receipts {
+		byHash[r.TxHash.Hex()] = r
+	}
+
+	kept := msgs[:0]
+	for _, m := range msgs {
+		r, ok := byHash[m.Hash]
+		if !ok {
+			kept = append(kept, m)
+			continue
+		}
+		if r.Status == types.ReceiptStatusFailed && w.config.SkipReverted {
+			continue
+		}
+
+		status := r.Status
+		m.Status = &status
+		m.GasUsed = r.GasUsed
+		if r.EffectiveGasPrice != nil {
+			m.EffectiveGasPrice = r.EffectiveGasPrice.String()
+		}
+		m.Fee = fee(r).String()
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// fee returns the amount paid by the sender: the execution gas and the blob gas.
+func fee(r *types.Receipt) *big.Int {
+	total := new(big.Int)
+	if r.EffectiveGasPrice != nil {
+		total.Mul(new(big.Int).SetUint64(r.GasUsed), r.EffectiveGasPrice)
+	}
+	if r.BlobGasPrice != nil {
+		total.Add(total, new(big.Int).Mul(new(big.Int).SetUint64(r.BlobGasUsed), r.BlobGasPrice))
+	}
+	return total
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"log"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"deblockTest/pkg"
+)
+
+// chainTracker keeps the hashes of the last `depth` canonical blocks, and the messages
+// published for each of them, so that orphaned blocks can be retracted after a reorg.
+type chainTracker struct {
+	depth  uint64
+	lowest uint64
+	blocks map[uint64]*trackedBlock
+}
+
+type trackedBlock struct {
+	hash common.Hash
+	msgs []pkg.TxMessage
+}
+
+func newChainTracker(depth uint64) *chainTracker {
+	return &chainTracker{depth: depth, blocks: make(map[uint64]*trackedBlock, depth)}
+}
+
+func (c *chainTracker) hash(number uint64) (common.Hash, bool) {
+	b, ok := c.blocks[number]
+	if !ok {
+		return common.Hash{}, false
+	}
+	return b.hash, true
+}
+
+func (c *chainTracker) add(number uint64, hash common.Hash) {
+	if len(c.blocks) == 0 || number < c.lowest {
+		c.lowest = number
+	}
+	c.blocks[number] = &trackedBlock{hash: hash}
+
+	for number >= c.depth && c.lowest <= number-c.depth {
+		delete(c.blocks, c.lowest)
+		c.lowest++
+	}
+}
+
+// record attaches the published messages to a tracked block. It returns false when the
+// worker processed a block that is not (or no longer) part of the tracked canonical chain.
+func (c *chainTracker) record(number uint64, hash common.Hash, msgs []pkg.TxMessage) bool {
+	b, ok := c.blocks[number]
+	if !ok {
+		// Older than the window: nothing to compare against, trust the worker.
+		return number < c.lowest
+	}
+	if b.hash != hash {
+		return false
+	}
+	b.msgs = append(b.msgs, msgs...)
+	return true
+}
+
+// rollback forgets every block above ancestor and returns the messages published for them.
+func (c *chainTracker) rollback(ancestor uint64) []pkg.TxMessage {
+	var orphaned []pkg.TxMessage
+	for number, b := range c.blocks {
+		if number > ancestor {
+			orphaned = append(orphaned, b.msgs...)
+			delete(c.blocks, number)
+		}
+	}
+	return orphaned
+}
+
+// track verifies that block `number` extends the tracked chain and records its hash.
+// On a parent hash mismatch it walks back to the common ancestor, retracts the orphaned
+// messages, rewinds the cursors so the canonical branch is re-processed and returns false.
+func (s *Service) track(ctx context.Context, number uint64) (bool, error) {
+	header, err := s.client.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return false, err
+	}
+
+	if parent, ok := s.chain.hash(number - 1); ok && parent != header.ParentHash {
+		ancestor, err := s.findAncestor(ctx, number-1)
+		if err != nil {
+			return false, err
+		}
+		log.Printf("Reorg detected at block %d, rolling back to common ancestor %d", number, ancestor)
+		s.retract(ctx, s.chain.rollback(ancestor))
+		s.nextSeenBlock = min(s.nextSeenBlock, ancestor+1)
+		s.nextBlock = min(s.nextBlock, ancestor+1)
+		if s.nextExpectedBlock > ancestor+1 {
+			s.nextExpectedBlock = ancestor + 1
+			_ = s.state.SaveCheckpoint(ancestor)
+		}
+		return false, nil
+	}
+
+	s.chain.add(number, header.Hash())
+	return true, nil
+}
+
+func (s *Service) findAncestor(ctx context.Context, from uint64) (uint64, error) {
+	for number := from; number > 0; number-- {
+		known, ok := s.chain.hash(number)
+		if !ok {
+			log.Printf("Reorg deeper than %d blocks, cannot retract before block %d", s.config.ReorgDepth, number+1)
+			return number, nil
+		}
+		header, err := s.client.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return 0, err
+		}
+		if header.Hash() == known {
+			return number, nil
+		}
+	}
+	return 0, nil
+}
+
+// retract publishes a removal event for each message of an orphaned block.
+func (s *Service) retract(ctx context.Context, msgs []pkg.TxMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+	removed := make([]pkg.TxMessage, len(msgs))
+	for i, m := range msgs {
+		m.Event = pkg.EventRemoved
+		removed[i] = m
+	}
+	s.publisher.Publish(ctx, removed)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"deblockTest/pkg"
+)
+
+// processReward reports what a watched coinbase earned from the block: the priority fees of its
+// transactions, and the ETH the transactions sent it, directly or through internal calls.
+// Gas used is only known from receipts, so nothing is reported without them.
+func (w *Worker) processReward(block *types.Block, receipts []*types.Receipt, frames []pkg.CallFrame) []pkg.TxMessage {
+	if receipts == nil {
+		return nil
+	}
+	coinbase := block.Coinbase()
+	if _, ok := w.userGetter.Lookup(coinbase); !ok {
+		return nil
+	}
+
+	// Receipts are matched by hash: the L2 system transactions are not in the block, see batch.Client.
+	txs := make(map[common.Hash]*types.Transaction, block.Transactions().Len())
+	for _, tx := range block.Transactions() {
+		txs[tx.Hash()] = tx
+	}
+	fees, payment := new(big.Int), new(big.Int)
+	for _, r := range receipts {
+		tx, ok := txs[r.TxHash]
+		if !ok {
+			continue
+		}
+		fees.Add(fees, new(big.Int).Mul(priorityFee(tx, r, block.BaseFee()), new(big.Int).SetUint64(r.GasUsed)))
+
+		// The builder paying the proposer from the coinbase is not an income.
+		if to := tx.To(); to != nil && *to == coinbase && r.Status == types.ReceiptStatusSuccessful {
+			if from, err := types.Sender(w.signer, tx); err == nil && from != coinbase {
+				payment.Add(payment, tx.Value())
+			}
+		}
+	}
+	for _, frame := range frames {
+		if frame.To == coinbase && frame.From != coinbase {
+			payment.Add(payment, frame.Value)
+		}
+	}
+
+	return w.match(nil, nil, &coinbase, pkg.TxMessage{
+		To:             coinbase.Hex(),
+		Amount:         new(big.Int).Add(fees, payment).String(),
+		BlockNumber:    block.NumberU64(),
+		BlockHash:      block.Hash().Hex(),
+		Type:           pkg.TypeReward,
+		AssetType:      pkg.AssetNative,
+		PriorityFees:   fees.String(),
+		BuilderPayment: payment.String(),
+	})
+}
+
+// priorityFee returns the tip per gas paid to the coinbase: what is left of the gas price once the base fee is burnt.
+func priorityFee(tx *types.Transaction, r *types.Receipt, baseFee *big.Int) *big.Int {
+	if r.EffectiveGasPrice == nil {
+		tip, err := tx.EffectiveGasTip(baseFee)
+		if err != nil {
+			return new(big.Int)
+		}
+		return tip
+	}
+	if baseFee == nil {
+		return r.EffectiveGasPrice
+	}
+	return new(big.Int).Sub(r.EffectiveGasPrice, baseFee)
+}
@@ -3,27 +3,33 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"math/big"
 	"time"
 
 	"deblockTest/pkg"
+	"deblockTest/ratelimit"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 type EthereumBlockGetter interface {
 	BlockNumber(ctx context.Context) (uint64, error)
 	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error)
 }
 
 type Publisher interface {
 	Publish(ctx context.Context, msgs []pkg.TxMessage)
 }
 
+// UserGetter is implemented by addressBook.AddressBook.
 type UserGetter interface {
-	GetUserID(addr common.Address) (string, bool)
+	Lookup(addr common.Address) (pkg.AddressRecord, bool)
 }
 
 type State interface {
@@ -32,15 +38,44 @@ type State interface {
 }
 
 type Service struct {
-	config         *Config
-	client         EthereumBlockGetter
-	userGetter     UserGetter
-	publisher      Publisher
-	state          State
-	blocks         chan uint64
-	retryChan      chan uint64
-	ackChan        chan uint64
-	processedCount uint64
+	config            *Config
+	client            EthereumBlockGetter
+	userGetter        UserGetter
+	publisher         Publisher
+	state             State
+	chain             *chainTracker
+	heads             HeadSource
+	tracer            BlockTracer
+	observer          BlockObserver
+	readiness         Readiness
+	metrics           Metrics
+	blocks            chan blockJob
+	retryChan         chan blockJob
+	ackChan           chan blockAck
+	processedCount    uint64
+	nextBlock         uint64
+	nextSeenBlock     uint64
+	nextExpectedBlock uint64
+}
+
+// blockJob asks a Worker to process a block and tag its messages with event.
+type blockJob struct {
+	number uint64
+	event  string
+	// block is set when the block was already fetched in a batch.
+	block *types.Block
+	// priority of the RPC requests of the job: catch-up and backfill give way to live blocks.
+	priority ratelimit.Priority
+}
+
+// catchUpDistance is how far behind the head a block must be to be fetched with low priority.
+const catchUpDistance = 16
+
+// blockAck is sent by a Worker once a block has been processed and its messages published.
+type blockAck struct {
+	blockJob
+	hash common.Hash
+	msgs []pkg.TxMessage
 }
 
 func NewService(config *Config, ethClient EthereumBlockGetter, ug UserGetter, p Publisher, s State) *Service {
@@ -54,103 +89,261 @@ func NewService(config *Config, ethClient EthereumBlockGetter, ug UserGetter, p
 }
 
 func (s *Service) Setup(ctx context.Context) {
-	s.blocks = make(chan uint64, 1000)
-	s.retryChan = make(chan uint64, 1000)
-	s.ackChan = make(chan uint64, 1000)
+	s.blocks = make(chan blockJob, 1000)
+	s.retryChan = make(chan blockJob, 1000)
+	s.ackChan = make(chan blockAck, 1000)
+	if s.config.ReorgDepth > 0 {
+		s.chain = newChainTracker(s.config.ReorgDepth)
+	}
+	if s.heads == nil {
+		s.heads = s.pollingHeads()
+	}
+
+	chainID := s.config.ChainID
+	if chainID == 0 {
+		chainID = 1
+	}
+	signer := types.LatestSignerForChainID(new(big.Int).SetUint64(chainID))
+
+	// Workers get their jobs straight from the queue, or through the batch fetcher.
+	var jobs <-chan blockJob = s.blocks
+	if batcher, ok := s.client.(BlockBatcher); ok && s.config.BatchSize > 1 {
+		fetched := make(chan blockJob, s.config.BatchSize)
+		go s.batchBlocks(ctx, batcher, s.blocks, fetched)
+		jobs = fetched
+	}
 
 	for i := 0; i < s.config.WorkerCount; i++ {
 		w := Worker{
+			config:     s.config,
 			client:     s.client,
 			userGetter: s.userGetter,
 			publisher:  s.publisher,
-			blocks:     s.blocks,
+			blocks:     jobs,
 			retryChan:  s.retryChan,
 			ackChan:    s.ackChan,
+			keepMsgs:   s.chain != nil,
+			tracer:     s.tracer,
+			observer:   s.observer,
+			chainID:    chainID,
+			signer:     signer,
+			metrics:    &s.metrics,
 		}
 		go w.Run(ctx)
 	}
 }
 
 func (s *Service) Run(ctx context.Context) {
-	// Automatically merge blocks to rety in blockChan.
-	go func() {
-		for blockNum := range s.retryChan {
-			time.Sleep(200 * time.Millisecond)
-			s.blocks <- blockNum
-		}
-	}()
+	if !s.waitReady(ctx) {
+		return
+	}
+	go s.mergeRetries()
 
 	checkpoint := s.state.LoadCheckpoint()
-	latest, err := s.client.BlockNumber(ctx)
-	if err != nil {
-		log.Fatal("Failed to get latest block on startup:", err)
+	latest, ok := s.startupHead(ctx)
+	if !ok {
+		return
 	}
 
 	var startBlock uint64
 	if checkpoint == 0 {
 		// First run ever, we jump to real-time.
 		// log.Printf("No checkpoint found, starting real-time mode from block %d", latest)
-		startBlock = latest
+		startBlock = s.confirmedBlock(latest)
 	} else {
 		// Resume from where we stopped last time.
 		startBlock = checkpoint + 1
 		// log.Printf("Starting from checkpoint at block %d", startBlock)
 	}
 
-	var nextExpectedBlock = startBlock
-	var lastKnown uint64
+	// from my understanding, eth chain can sometimes be reorged : https://www.cube.exchange/what-is/chain-reorganization
+	if startBlock > latest {
+		startBlock = latest
+	}
+
+	s.nextBlock, s.nextSeenBlock, s.nextExpectedBlock = startBlock, startBlock, startBlock
+	heads := s.heads.Heads(ctx)
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
+		case latest, ok := <-heads:
+			if !ok {
+				return
+			}
+			s.advance(ctx, latest)
+		case ack := <-s.ackChan:
+			s.handleAck(ctx, ack)
 		}
+	}
+}
 
-		latest, err := s.client.BlockNumber(ctx)
-		if err != nil {
-			log.Printf("Failed to get latest block: %v", err)
-			time.Sleep(2 * time.Second)
-			continue
+// startupHead fetches the head until it succeeds, so that an unavailable provider does not stop
+// the pipelines of the other chains. It returns false if ctx is done first.
+func (s *Service) startupHead(ctx context.Context) (uint64, bool) {
+	for {
+		latest, err := s.headNumber(ctx)
+		if err == nil {
+			return latest, true
 		}
+		log.Printf("Failed to get latest block on startup, retrying: %v", err)
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// mergeRetries automatically merges blocks to retry in blockChan.
+func (s *Service) mergeRetries() {
+	for job := range s.retryChan {
+		time.Sleep(200 * time.Millisecond)
+		s.blocks <- job
+	}
+}
+
+// SubscribeHeads makes the service follow newHeads notifications instead of polling.
+// While the subscription is down the service polls, and the missed blocks are caught up.
+// It must be called before Setup.
+func (s *Service) SubscribeHeads(client HeadSubscriber) {
+	source := &subscriptionHeadSource{
+		client:        client,
+		fallback:      s.pollingHeads(),
+		retryInterval: s.config.ResubscribeInterval,
+	}
+	if source.retryInterval == 0 {
+		source.retryInterval = 30 * time.Second
+	}
+	if s.config.HeadTag != "" && s.config.HeadTag != HeadLatest {
+		source.resolve = s.headNumber
+	}
+	s.heads = source
+}
 
-		if lastKnown == 0 {
-			// from my understanding, eth chain can sometimes be reorged : https://www.cube.exchange/what-is/chain-reorganization
-			if startBlock > latest {
-				startBlock = latest
+func (s *Service) pollingHeads() HeadSource {
+	interval := s.config.PollInterval
+	if interval == 0 {
+		// Polling without pause would spin on BlockNumber.
+		interval = time.Second
+	}
+	return &pollingHeadSource{fetch: s.headNumber, interval: interval}
+}
+
+// advance enqueues every block up to the new head.
+func (s *Service) advance(ctx context.Context, latest uint64) {
+	if s.config.EmitSeen {
+		// Two-phase mode: every block is seen at head, then confirmed once deep enough.
+		s.enqueue(ctx, &s.nextSeenBlock, latest, pkg.EventSeen, s.chain != nil)
+		s.enqueue(ctx, &s.nextBlock, min(s.confirmedBlock(latest), s.nextSeenBlock-1), pkg.EventConfirmed, false)
+	} else {
+		s.enqueue(ctx, &s.nextBlock, s.confirmedBlock(latest), s.finalEvent(), s.chain != nil)
+	}
+}
+
+// headNumber returns the upper bound of ingestion: the latest block, or the block behind the
+// configured safe or finalized tag, which cannot be reorged.
+func (s *Service) headNumber(ctx context.Context) (uint64, error) {
+	var tag rpc.BlockNumber
+	switch s.config.HeadTag {
+	case "", HeadLatest:
+		return s.client.BlockNumber(ctx)
+	case HeadSafe:
+		tag = rpc.SafeBlockNumber
+	case HeadFinalized:
+		tag = rpc.FinalizedBlockNumber
+	default:
+		return 0, fmt.Errorf("unknown head tag %q", s.config.HeadTag)
+	}
+
+	header, err := s.client.HeaderByNumber(ctx, big.NewInt(tag.Int64()))
+	if err != nil {
+		return 0, err
+	}
+	return header.Number.Uint64(), nil
+}
+
+// enqueue hands the blocks from cursor up to `to` to the workers. When track is set, each block is
+// first checked against the tracked chain, and a reorg rewinds the cursors to the common ancestor.
+func (s *Service) enqueue(ctx context.Context, cursor *uint64, to uint64, event string, track bool) {
+	for *cursor <= to {
+		number := *cursor
+		if track {
+			ok, err := s.track(ctx, number)
+			if err != nil {
+				log.Printf("Failed to get header of block %d: %v", number, err)
+				return
+			}
+			if !ok {
+				continue
 			}
-			lastKnown = latest
 		}
-
-		for current := startBlock; current <= latest; current++ {
-			s.blocks <- current
-			startBlock = current + 1
+		job := blockJob{number: number, event: event}
+		if to-number > catchUpDistance {
+			job.priority = ratelimit.Low
 		}
+		s.send(ctx, job)
+		*cursor = number + 1
+	}
+}
 
-		if latest > lastKnown {
-			lastKnown = latest
+// send hands a job to the workers, handling acks meanwhile so that workers blocked on a full
+// ack channel cannot dead-lock the service while it catches up.
+func (s *Service) send(ctx context.Context, job blockJob) {
+	for {
+		select {
+		case s.blocks <- job:
+			return
+		case ack := <-s.ackChan:
+			s.handleAck(ctx, ack)
+		case <-ctx.Done():
+			return
 		}
+	}
+}
 
-		drained := true
-		for drained {
-			select {
-			case ackedBlock := <-s.ackChan:
-				s.processedCount++
-				if ackedBlock == nextExpectedBlock {
-					nextExpectedBlock++
-					// Save checkpoint every 5 confirmed blocks
-					if nextExpectedBlock%5 == 0 {
-						// log.Printf("Saving checkpoint at block %d", nextExpectedBlock-1)
-						_ = s.state.SaveCheckpoint(nextExpectedBlock - 1)
-					}
-				}
-			default:
-				drained = false
-			}
+// confirmedBlock returns the highest block that is at least Confirmations blocks behind latest.
+func (s *Service) confirmedBlock(latest uint64) uint64 {
+	if latest < s.config.Confirmations {
+		return 0
+	}
+	return latest - s.config.Confirmations
+}
+
+func (s *Service) finalEvent() string {
+	if s.config.Confirmations > 0 {
+		return pkg.EventConfirmed
+	}
+	return pkg.EventMined
+}
+
+func (s *Service) handleAck(ctx context.Context, ack blockAck) {
+	if s.chain != nil && !s.chain.record(ack.number, ack.hash, ack.msgs) {
+		s.retract(ctx, ack.msgs)
+		if _, tracked := s.chain.hash(ack.number); tracked {
+			// The tracked header is canonical but the worker got the body of another branch, from a
+			// stale or lagging provider: fetch the block again.
+			job := ack.blockJob
+			job.block = nil
+			s.send(ctx, job)
 		}
+		// Otherwise the block was orphaned meanwhile, and the canonical one is re-queued by track.
+		return
+	}
+	if ack.event == pkg.EventSeen {
+		// Only the final phase moves the checkpoint.
+		return
+	}
 
-		// log.Printf("waiting for a new block...")
-		time.Sleep(s.config.PollInterval)
+	s.processedCount++
+	if ack.number == s.nextExpectedBlock {
+		s.nextExpectedBlock++
+		// Save checkpoint every 5 confirmed blocks
+		if s.nextExpectedBlock%5 == 0 {
+			// log.Printf("Saving checkpoint at block %d", s.nextExpectedBlock-1)
+			_ = s.state.SaveCheckpoint(s.nextExpectedBlock - 1)
+		}
 	}
 }
 
-func (s *Service) TestBlockChan() chan<- uint64 { return s.blocks }
+func (s *Service) TestBlockChan() chan<- blockJob { return s.blocks }
@@ -34,10 +34,10 @@ func init() {
 	}
 	ab = addressBook.NewFromConfig(cfg)
 
-	addresses := make(map[common.Address]string, 500_000)
+	addresses := make(map[common.Address]pkg.AddressRecord, 500_000)
 	for i := 0; i < 500_000; i++ {
 		addr := common.BytesToAddress(crypto.Keccak256([]byte{byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i)})[12:])
-		addresses[addr] = "user-" + string(rune(i))
+		addresses[addr] = pkg.Owner("user-" + string(rune(i)))
 		localAB[i] = addr
 	}
 	ab.SetAddresses(addresses)
@@ -104,7 +104,7 @@ func BenchmarkService_RealThroughput(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		blockNum := uint64(19999001 + i)
-		svc.TestBlockChan() <- blockNum
+		svc.TestBlockChan() <- blockJob{number: blockNum, event: pkg.EventMined}
 	}
 
 	for len(svc.TestBlockChan()) > 0 || atomic.LoadUint64(&svc.processedCount) < uint64(b.N) {
@@ -0,0 +1,489 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"go.uber.org/mock/gomock"
+
+	"deblockTest/pkg"
+	"deblockTest/service/mocks"
+)
+
+var watched = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+// fakeChain serves blocks from a canonical chain that tests can swap to simulate reorgs.
+type fakeChain struct {
+	mu     sync.Mutex
+	blocks map[uint64]*types.Block
+	head   uint64
+}
+
+func (c *fakeChain) set(head uint64, blocks map[uint64]*types.Block) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.head, c.blocks = head, blocks
+}
+
+func (c *fakeChain) BlockNumber(context.Context) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head, nil
+}
+
+func (c *fakeChain) BlockByNumber(_ context.Context, number *big.Int) (*types.Block, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.blocks[number.Uint64()]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return b, nil
+}
+
+func (c *fakeChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	b, err := c.BlockByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return b.Header(), nil
+}
+
+func (c *fakeChain) BlockReceipts(context.Context, rpc.BlockNumberOrHash) ([]*types.Receipt, error) {
+	return nil, nil
+}
+
+type fakeUsers map[common.Address]string
+
+func (u fakeUsers) Lookup(addr common.Address) (pkg.AddressRecord, bool) {
+	id, ok := u[addr]
+	return pkg.Owner(id), ok
+}
+
+type recordingPublisher struct {
+	mu   sync.Mutex
+	msgs []pkg.TxMessage
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, msgs []pkg.TxMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, msgs...)
+}
+
+func (p *recordingPublisher) has(event string, block *types.Block) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.msgs {
+		if m.Event == event && m.BlockHash == block.Hash().Hex() {
+			return true
+		}
+	}
+	return false
+}
+
+type memState struct {
+	mu         sync.Mutex
+	checkpoint uint64
+}
+
+func (s *memState) LoadCheckpoint() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkpoint
+}
+
+func (s *memState) SaveCheckpoint(blockNum uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoint = blockNum
+	return nil
+}
+
+// makeChildBlock builds a block on top of parent holding a single transfer to the watched address.
+func makeChildBlock(parent *types.Block, value int64) *types.Block {
+	tx, _ := types.SignTx(types.NewTx(&types.LegacyTx{
+		Nonce:    parent.NumberU64() + 1,
+		To:       &watched,
+		Value:    big.NewInt(value),
+		Gas:      21000,
+		GasPrice: big.NewInt(1e9),
+	}), testSigner, testKey)
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+	}
+	return types.NewBlock(header, &types.Body{Transactions: []*types.Transaction{tx}}, nil, trie.NewStackTrie(nil))
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestService_Reorg(t *testing.T) {
+	a10 := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(10)})
+	a11 := makeChildBlock(a10, 1)
+	a12 := makeChildBlock(a11, 1)
+	b11 := makeChildBlock(a10, 2)
+	b12 := makeChildBlock(b11, 2)
+	b13 := makeChildBlock(b12, 2)
+
+	chain := &fakeChain{}
+	chain.set(10, map[uint64]*types.Block{10: a10, 11: a11, 12: a12})
+	pub := &recordingPublisher{}
+	state := &memState{}
+
+	svc := NewService(
+		&Config{PollInterval: 5 * time.Millisecond, WorkerCount: 2, ReorgDepth: 8},
+		chain, fakeUsers{watched: "user-1"}, pub, state,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc.Setup(ctx)
+	go svc.Run(ctx)
+
+	time.Sleep(20 * time.Millisecond)
+	chain.set(12, map[uint64]*types.Block{10: a10, 11: a11, 12: a12})
+	waitFor(t, "chain A to be published", func() bool {
+		return pub.has(pkg.EventMined, a11) && pub.has(pkg.EventMined, a12)
+	})
+
+	chain.set(13, map[uint64]*types.Block{10: a10, 11: b11, 12: b12, 13: b13})
+	waitFor(t, "chain B to replace chain A", func() bool {
+		return pub.has(pkg.EventRemoved, a11) && pub.has(pkg.EventRemoved, a12) &&
+			pub.has(pkg.EventMined, b11) && pub.has(pkg.EventMined, b12) && pub.has(pkg.EventMined, b13)
+	})
+
+	if pub.has(pkg.EventRemoved, b11) || pub.has(pkg.EventRemoved, b12) {
+		t.Error("canonical blocks must not be retracted")
+	}
+}
+
+// staleBodyChain serves the body of another branch once for the blocks in stale, as a lagging
+// provider would, while headers come from the canonical chain.
+type staleBodyChain struct {
+	*fakeChain
+	mu    sync.Mutex
+	stale map[uint64]*types.Block
+}
+
+func (c *staleBodyChain) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	c.mu.Lock()
+	b, ok := c.stale[number.Uint64()]
+	delete(c.stale, number.Uint64())
+	c.mu.Unlock()
+	if ok {
+		return b, nil
+	}
+	return c.fakeChain.BlockByNumber(ctx, number)
+}
+
+func TestService_StaleBody(t *testing.T) {
+	a10 := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(10)})
+	a11 := makeChildBlock(a10, 1)
+	a12 := makeChildBlock(a11, 1)
+	a13 := makeChildBlock(a12, 1)
+	b11 := makeChildBlock(a10, 2)
+
+	chain := &staleBodyChain{fakeChain: &fakeChain{}, stale: map[uint64]*types.Block{11: b11}}
+	chain.set(10, map[uint64]*types.Block{10: a10, 11: a11, 12: a12, 13: a13})
+	pub := &recordingPublisher{}
+	state := &memState{checkpoint: 10}
+
+	svc := NewService(
+		&Config{PollInterval: 5 * time.Millisecond, WorkerCount: 2, ReorgDepth: 8},
+		chain, fakeUsers{watched: "user-1"}, pub, state,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc.Setup(ctx)
+	go svc.Run(ctx)
+
+	time.Sleep(20 * time.Millisecond)
+	chain.set(13, map[uint64]*types.Block{10: a10, 11: a11, 12: a12, 13: a13})
+	waitFor(t, "the stale body to be retracted and the canonical block published", func() bool {
+		return pub.has(pkg.EventRemoved, b11) && pub.has(pkg.EventMined, a11) &&
+			pub.has(pkg.EventMined, a12) && pub.has(pkg.EventMined, a13)
+	})
+	if pub.has(pkg.EventRemoved, a11) {
+		t.Error("the canonical block must not be retracted")
+	}
+}
+
+func TestService_ConfirmationDepth(t *testing.T) {
+	blocks := map[uint64]*types.Block{10: types.NewBlockWithHeader(&types.Header{Number: big.NewInt(10)})}
+	for n := uint64(11); n <= 21; n++ {
+		blocks[n] = makeChildBlock(blocks[n-1], 1)
+	}
+
+	chain := &fakeChain{}
+	chain.set(20, blocks)
+	pub := &recordingPublisher{}
+
+	svc := NewService(
+		&Config{PollInterval: 5 * time.Millisecond, WorkerCount: 2, ReorgDepth: 8, Confirmations: 3, EmitSeen: true},
+		chain, fakeUsers{watched: "user-1"}, pub, &memState{},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc.Setup(ctx)
+	go svc.Run(ctx)
+
+	waitFor(t, "head blocks to be seen", func() bool {
+		return pub.has(pkg.EventSeen, blocks[18]) && pub.has(pkg.EventSeen, blocks[20]) && pub.has(pkg.EventConfirmed, blocks[17])
+	})
+
+	chain.set(21, blocks)
+	waitFor(t, "block 18 to be confirmed", func() bool {
+		return pub.has(pkg.EventSeen, blocks[21]) && pub.has(pkg.EventConfirmed, blocks[18])
+	})
+
+	for n := uint64(19); n <= 21; n++ {
+		if pub.has(pkg.EventConfirmed, blocks[n]) {
+			t.Errorf("block %d confirmed before reaching the confirmation depth", n)
+		}
+	}
+}
+
+func TestService_HeadTag(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ethMock := mocks.NewMockEthereumBlockGetter(ctrl)
+	ethMock.EXPECT().BlockNumber(gomock.Any()).Return(uint64(120), nil)
+	ethMock.EXPECT().HeaderByNumber(gomock.Any(), big.NewInt(rpc.SafeBlockNumber.Int64())).
+		Return(&types.Header{Number: big.NewInt(110)}, nil)
+	ethMock.EXPECT().HeaderByNumber(gomock.Any(), big.NewInt(rpc.FinalizedBlockNumber.Int64())).
+		Return(&types.Header{Number: big.NewInt(100)}, nil)
+
+	for tag, expected := range map[string]uint64{HeadLatest: 120, HeadSafe: 110, HeadFinalized: 100} {
+		svc := NewService(&Config{HeadTag: tag}, ethMock, nil, nil, nil)
+		head, err := svc.headNumber(context.Background())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tag, err)
+		}
+		if head != expected {
+			t.Errorf("%s: expected head %d, got %d", tag, expected, head)
+		}
+	}
+
+	svc := NewService(&Config{HeadTag: "pending"}, ethMock, nil, nil, nil)
+	if _, err := svc.headNumber(context.Background()); err == nil {
+		t.Error("expected an error for an unknown head tag")
+	}
+}
+
+func TestService_StartupHeadRetries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ethMock := mocks.NewMockEthereumBlockGetter(ctrl)
+	gomock.InOrder(
+		ethMock.EXPECT().BlockNumber(gomock.Any()).Return(uint64(0), errors.New("connection refused")),
+		ethMock.EXPECT().BlockNumber(gomock.Any()).Return(uint64(42), nil),
+	)
+
+	svc := NewService(&Config{}, ethMock, nil, nil, nil)
+	if head, ok := svc.startupHead(context.Background()); !ok || head != 42 {
+		t.Errorf("expected head 42 after a retry, got %d, %v", head, ok)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ethMock.EXPECT().BlockNumber(gomock.Any()).Return(uint64(0), context.Canceled)
+	if _, ok := svc.startupHead(ctx); ok {
+		t.Error("expected no head once the context is done")
+	}
+}
+
+func TestService_Backfill(t *testing.T) {
+	blocks := map[uint64]*types.Block{10: types.NewBlockWithHeader(&types.Header{Number: big.NewInt(10)})}
+	for n := uint64(11); n <= 30; n++ {
+		blocks[n] = makeChildBlock(blocks[n-1], 1)
+	}
+
+	chain := &fakeChain{}
+	chain.set(30, blocks)
+	pub := &recordingPublisher{}
+	// A previous run stopped after block 14.
+	state := &memState{checkpoint: 14}
+
+	svc := NewService(&Config{WorkerCount: 4}, chain, fakeUsers{watched: "user-1"}, pub, state)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	svc.Setup(ctx)
+
+	if err := svc.Backfill(ctx, 11, 25); err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+
+	for n := uint64(11); n <= 30; n++ {
+		expected := n >= 15 && n <= 25
+		if published := pub.has(pkg.EventMined, blocks[n]); published != expected {
+			t.Errorf("block %d: expected published=%v, got %v", n, expected, published)
+		}
+	}
+	if checkpoint := state.LoadCheckpoint(); checkpoint != 0 {
+		t.Errorf("expected the checkpoint of the finished backfill to be reset, got %d", checkpoint)
+	}
+
+	if err := svc.Backfill(ctx, 25, 11); err == nil {
+		t.Error("expected an error for an inverted range")
+	}
+	if err := svc.Backfill(ctx, 25, 31); err == nil {
+		t.Error("expected an error for a range above the head")
+	}
+
+	// Running the same range again, e.g. after onboarding addresses, re-indexes all of it.
+	pub = &recordingPublisher{}
+	svc = NewService(&Config{WorkerCount: 4}, chain, fakeUsers{watched: "user-1"}, pub, state)
+	svc.Setup(ctx)
+	if err := svc.Backfill(ctx, 11, 25); err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+	for n := uint64(11); n <= 25; n++ {
+		if !pub.has(pkg.EventMined, blocks[n]) {
+			t.Errorf("block %d: expected to be published again", n)
+		}
+	}
+}
+
+func TestService_BackfillFromGenesis(t *testing.T) {
+	// Block 0 holds a transfer too, built on top of a parent numbered -1.
+	blocks := map[uint64]*types.Block{0: makeChildBlock(types.NewBlockWithHeader(&types.Header{Number: big.NewInt(-1)}), 1)}
+	for n := uint64(1); n <= 3; n++ {
+		blocks[n] = makeChildBlock(blocks[n-1], 1)
+	}
+	chain := &fakeChain{}
+	chain.set(3, blocks)
+	pub := &recordingPublisher{}
+
+	// No checkpoint yet.
+	svc := NewService(&Config{WorkerCount: 2}, chain, fakeUsers{watched: "user-1"}, pub, &memState{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	svc.Setup(ctx)
+	if err := svc.Backfill(ctx, 0, 3); err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+
+	for n := uint64(0); n <= 3; n++ {
+		if !pub.has(pkg.EventMined, blocks[n]) {
+			t.Errorf("expected block %d to be published", n)
+		}
+	}
+}
+
+// batchingChain serves blocks in batches, failing the blocks in failing so that workers fetch them alone.
+type batchingChain struct {
+	*fakeChain
+	mu      sync.Mutex
+	batches [][]uint64
+	failing map[uint64]bool
+}
+
+func (c *batchingChain) BlocksByNumber(ctx context.Context, numbers []uint64) ([]*types.Block, []error) {
+	c.mu.Lock()
+	c.batches = append(c.batches, slices.Clone(numbers))
+	c.mu.Unlock()
+
+	blocks, errs := make([]*types.Block, len(numbers)), make([]error, len(numbers))
+	for i, n := range numbers {
+		if c.failing[n] {
+			errs[i] = errors.New("batch item failed")
+			continue
+		}
+		blocks[i], errs[i] = c.BlockByNumber(ctx, new(big.Int).SetUint64(n))
+	}
+	return blocks, errs
+}
+
+func TestService_BatchFetching(t *testing.T) {
+	blocks := map[uint64]*types.Block{0: types.NewBlockWithHeader(&types.Header{Number: big.NewInt(0)})}
+	for n := uint64(1); n <= 40; n++ {
+		blocks[n] = makeChildBlock(blocks[n-1], 1)
+	}
+	chain := &batchingChain{fakeChain: &fakeChain{}, failing: map[uint64]bool{7: true}}
+	chain.set(40, blocks)
+	pub := &recordingPublisher{}
+
+	svc := NewService(&Config{WorkerCount: 2, BatchSize: 8}, chain, fakeUsers{watched: "user-1"}, pub, &memState{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	svc.Setup(ctx)
+
+	if err := svc.Backfill(ctx, 1, 40); err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+
+	for n := uint64(1); n <= 40; n++ {
+		if !pub.has(pkg.EventMined, blocks[n]) {
+			t.Errorf("block %d not published", n)
+		}
+	}
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	largest := 0
+	for _, batch := range chain.batches {
+		if len(batch) > 8 {
+			t.Errorf("batch of %d blocks exceeds the batch size", len(batch))
+		}
+		largest = max(largest, len(batch))
+	}
+	if largest < 2 || len(chain.batches) >= 40 {
+		t.Errorf("expected blocks to be grouped, got batches %v", chain.batches)
+	}
+}
+
+type gate chan struct{}
+
+func (g gate) Ready() <-chan struct{} {
+	return g
+}
+
+func TestService_WaitFor(t *testing.T) {
+	blocks := map[uint64]*types.Block{10: types.NewBlockWithHeader(&types.Header{Number: big.NewInt(10)})}
+	blocks[11] = makeChildBlock(blocks[10], 1)
+
+	chain := &fakeChain{}
+	chain.set(11, blocks)
+	pub := &recordingPublisher{}
+
+	svc := NewService(&Config{WorkerCount: 1}, chain, fakeUsers{watched: "user-1"}, pub, &memState{checkpoint: 10})
+	ready := make(gate)
+	svc.WaitFor(ready)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	svc.Setup(ctx)
+
+	done := make(chan error)
+	go func() { done <- svc.Backfill(ctx, 11, 11) }()
+
+	time.Sleep(50 * time.Millisecond)
+	if pub.has(pkg.EventMined, blocks[11]) {
+		t.Fatal("expected no block to be processed before the service is ready")
+	}
+
+	close(ready)
+	if err := <-done; err != nil {
+		t.Fatalf("Backfill failed: %v", err)
+	}
+	if !pub.has(pkg.EventMined, blocks[11]) {
+		t.Error("expected block 11 to be processed once ready")
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"deblockTest/pkg"
+)
+
+// BlockTracer returns the internal calls of a block that moved ETH, see tracer.Tracer.
+type BlockTracer interface {
+	TraceBlock(ctx context.Context, number uint64, hash common.Hash) ([]pkg.CallFrame, error)
+}
+
+// SetTracer enables the detection of internal ETH transfers, sent by contracts, through call traces.
+// It must be called before Setup.
+func (s *Service) SetTracer(t BlockTracer) {
+	s.tracer = t
+}
+
+// processTraces matches the internal ETH transfers of a block against the watched addresses.
+func (w *Worker) processTraces(block *types.Block, frames []pkg.CallFrame) []pkg.TxMessage {
+	var msgs []pkg.TxMessage
+	for _, frame := range frames {
+		msgs = w.match(msgs, &frame.From, &frame.To, pkg.TxMessage{
+			From:         frame.From.Hex(),
+			To:           frame.To.Hex(),
+			Amount:       frame.Value.String(),
+			Hash:         frame.TxHash.Hex(),
+			BlockNumber:  block.NumberU64(),
+			BlockHash:    block.Hash().Hex(),
+			Type:         pkg.TypeTransfer,
+			AssetType:    pkg.AssetNative,
+			TraceAddress: frame.TraceAddress,
+		})
+	}
+	return msgs
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+
+	"deblockTest/pkg"
+)
+
+// processWithdrawals matches the beacon-chain withdrawals of a block against the watched addresses.
+// Withdrawals are credited by the consensus layer: they have no sender and no transaction.
+func (w *Worker) processWithdrawals(block *types.Block) []pkg.TxMessage {
+	var msgs []pkg.TxMessage
+	for _, wd := range block.Withdrawals() {
+		// Withdrawal amounts are in gwei.
+		amount := new(big.Int).Mul(new(big.Int).SetUint64(wd.Amount), big.NewInt(params.GWei))
+		index, validator := wd.Index, wd.Validator
+		msgs = w.match(msgs, nil, &wd.Address, pkg.TxMessage{
+			To:              wd.Address.Hex(),
+			Amount:          amount.String(),
+			BlockNumber:     block.NumberU64(),
+			BlockHash:       block.Hash().Hex(),
+			Type:            pkg.TypeWithdrawal,
+			AssetType:       pkg.AssetNative,
+			WithdrawalIndex: &index,
+			ValidatorIndex:  &validator,
+		})
+	}
+	return msgs
+}
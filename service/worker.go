@@ -2,93 +2,155 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"math/big"
 	"sync/atomic"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
 
 	"deblockTest/pkg"
+	"deblockTest/ratelimit"
 )
 
 type Worker struct {
+	config     *Config
 	client     EthereumBlockGetter
 	userGetter UserGetter
 	publisher  Publisher
 	state      State
-	blocks     <-chan uint64
+	blocks     <-chan blockJob
 	processed  *atomic.Uint64
-	retryChan  chan<- uint64
-	ackChan    chan<- uint64
+	retryChan  chan<- blockJob
+	ackChan    chan<- blockAck
+	keepMsgs   bool
+	tracer     BlockTracer
+	observer   BlockObserver
+	chainID    uint64
+	signer     types.Signer
+	metrics    *Metrics
 }
 
 func (w *Worker) Run(ctx context.Context) {
-	for blockNum := range w.blocks {
-		block, err := w.client.BlockByNumber(ctx, big.NewInt(int64(blockNum)))
+	for job := range w.blocks {
+		block, receipts, frames, err := w.fetch(ratelimit.WithPriority(ctx, job.priority), job)
 		if err != nil {
-			log.Printf("Failed to fetch block %d: %v (will retry later)", blockNum, err)
+			log.Printf("Failed to fetch block %d: %v (will retry later)", job.number, err)
 			time.Sleep(100 * time.Millisecond)
 			// This retry mechanism will break the in-order processing, but acceptable in 99% of case.
 			// if not acceptable we can introduce a local retry mechanism to make sure we handle each block after the previous one.
-			go func(b uint64) { w.retryChan <- b }(blockNum)
+			job.block = nil
+			go func(j blockJob) { w.retryChan <- j }(job)
 			continue
 		}
 
-		msgs := w.processBlock(block)
+		msgs := w.processBlock(block, receipts, frames)
+		for i := range msgs {
+			msgs[i].ChainID = w.chainID
+			msgs[i].Event = job.event
+		}
 		if len(msgs) > 0 {
 			w.publisher.Publish(ctx, msgs)
 		}
-		w.ackChan <- block.NumberU64()
+		if w.observer != nil {
+			w.observer.ObserveBlock(ctx, block)
+		}
+		job.block = nil
+		ack := blockAck{blockJob: job, hash: block.Hash()}
+		if w.keepMsgs {
+			ack.msgs = msgs
+		}
+		w.ackChan <- ack
+	}
+}
+
+// fetch gets a block along with its receipts and call traces when they are enabled.
+func (w *Worker) fetch(ctx context.Context, job blockJob) (*types.Block, []*types.Receipt, []pkg.CallFrame, error) {
+	block, number := job.block, job.number
+	if block == nil {
+		var err error
+		block, err = w.client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var receipts []*types.Receipt
+	var err error
+	if w.config.FetchReceipts {
+		receipts, err = w.client.BlockReceipts(ctx, rpc.BlockNumberOrHashWithHash(block.Hash(), false))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("receipts: %w", err)
+		}
+	}
+
+	var frames []pkg.CallFrame
+	if w.tracer != nil {
+		frames, err = w.tracer.TraceBlock(ctx, number, block.Hash())
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("traces: %w", err)
+		}
 	}
+	return block, receipts, frames, nil
 }
 
-func (w *Worker) processBlock(block *types.Block) []pkg.TxMessage {
+func (w *Worker) processBlock(block *types.Block, receipts []*types.Receipt, frames []pkg.CallFrame) []pkg.TxMessage {
 	var msgs []pkg.TxMessage
 	// log.Printf("Processing block %d\n", block.NumberU64())
 
 	for _, tx := range block.Transactions() {
-		chainID := tx.ChainId()
-		if chainID.Sign() == 0 {
-			continue
-		}
-		signer := types.NewLondonSigner(chainID)
-		from, _ := types.Sender(signer, tx)
 		to := tx.To()
-
-		userFrom, hasFrom := w.userGetter.GetUserID(from)
-		userTo := ""
-		hasTo := false
-		if to != nil {
-			userTo, hasTo = w.userGetter.GetUserID(*to)
+		var from *common.Address
+		if sender, err := types.Sender(w.signer, tx); err != nil {
+			// Still report the transfer to a watched recipient, with an unknown sender.
+			w.metrics.SenderRecoveryFailures.Add(1)
+			log.Printf("Warning: cannot recover sender of tx %s in block %d: %v", tx.Hash().Hex(), block.NumberU64(), err)
+		} else {
+			from = &sender
 		}
 
-		if !hasFrom && !hasTo {
+		msgs = w.match(msgs, from, to, pkg.TxMessage{
+			From:        pkg.ToStringPtr(from),
+			To:          pkg.ToStringPtr(to),
+			Amount:      tx.Value().String(),
+			Hash:        tx.Hash().Hex(),
+			BlockNumber: block.NumberU64(),
+			BlockHash:   block.Hash().Hex(),
+			Type:        pkg.TypeTransfer,
+			AssetType:   pkg.AssetNative,
+		})
+	}
+
+	msgs = append(msgs, w.processLogs(block, receipts)...)
+	msgs = append(msgs, w.processTraces(block, frames)...)
+	msgs = append(msgs, w.processWithdrawals(block)...)
+	msgs = append(msgs, w.processReward(block, receipts, frames)...)
+	if receipts != nil {
+		msgs = w.applyReceipts(msgs, receipts)
+	}
+	// log.Printf("Processed %d transactions, produced %d messages\n", len(block.Transactions()), len(msgs))
+	return msgs
+}
+
+// match appends a copy of msg for each owner of the sender and of the recipient when they are watched,
+// with the labels of the address.
+func (w *Worker) match(msgs []pkg.TxMessage, from, to *common.Address, msg pkg.TxMessage) []pkg.TxMessage {
+	for _, addr := range []*common.Address{from, to} {
+		if addr == nil {
 			continue
 		}
-
-		if hasFrom {
-			msgs = append(msgs, pkg.TxMessage{
-				UserID:      userFrom,
-				From:        from.Hex(),
-				To:          pkg.ToStringPtr(to),
-				Amount:      tx.Value().String(),
-				Hash:        tx.Hash().Hex(),
-				BlockNumber: block.NumberU64(),
-			})
+		record, ok := w.userGetter.Lookup(*addr)
+		if !ok {
+			continue
 		}
-
-		if hasTo {
-			msgs = append(msgs, pkg.TxMessage{
-				UserID:      userTo,
-				From:        from.Hex(),
-				To:          to.Hex(),
-				Amount:      tx.Value().String(),
-				Hash:        tx.Hash().Hex(),
-				BlockNumber: block.NumberU64(),
-			})
+		msg.Labels = record.Labels
+		for _, owner := range record.Owners {
+			msg.UserID = owner
+			msgs = append(msgs, msg)
 		}
 	}
-	// log.Printf("Processed %d transactions, produced %d messages\n", len(block.Transactions()), len(msgs))
 	return msgs
 }
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+
+	"deblockTest/pkg"
+)
+
+var (
+	usdc     = common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	stranger = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+)
+
+func TestWorker_ProcessBlock(t *testing.T) {
+	genesis := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(99)})
+	block := makeChildBlock(genesis, 1e15)
+	tx := block.Transactions()[0]
+
+	receipts := []*types.Receipt{{
+		Status: types.ReceiptStatusSuccessful,
+		TxHash: tx.Hash(),
+		Logs: []*types.Log{
+			{
+				Address: usdc,
+				Topics:  []common.Hash{transferTopic, common.BytesToHash(stranger.Bytes()), common.BytesToHash(watched.Bytes())},
+				Data:    common.LeftPadBytes(big.NewInt(2_500_000).Bytes(), 32),
+				TxHash:  tx.Hash(),
+				Index:   3,
+			},
+			{
+				// Transfer between two unwatched addresses.
+				Address: usdc,
+				Topics:  []common.Hash{transferTopic, common.BytesToHash(stranger.Bytes()), common.BytesToHash(usdc.Bytes())},
+				Data:    common.LeftPadBytes(big.NewInt(1).Bytes(), 32),
+				TxHash:  tx.Hash(),
+				Index:   4,
+			},
+		},
+	}}
+
+	w := &Worker{config: &Config{}, userGetter: fakeUsers{watched: "user-1"}, signer: testSigner, metrics: &Metrics{}}
+	msgs := w.processBlock(block, receipts, nil)
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d: %+v", len(msgs), msgs)
+	}
+
+	eth := msgs[0]
+	if eth.UserID != "user-1" || eth.To != watched.Hex() || eth.Amount != "1000000000000000" || eth.AssetType != pkg.AssetNative || eth.Type != pkg.TypeTransfer || eth.LogIndex != nil {
+		t.Errorf("unexpected ETH transfer message: %+v", eth)
+	}
+
+	token := msgs[1]
+	if token.UserID != "user-1" || token.From != stranger.Hex() || token.To != watched.Hex() || token.Amount != "2500000" {
+		t.Errorf("unexpected token transfer message: %+v", token)
+	}
+	if token.AssetType != pkg.AssetERC20 || token.Token != usdc.Hex() || token.LogIndex == nil || *token.LogIndex != 3 || token.Hash != tx.Hash().Hex() {
+		t.Errorf("unexpected token transfer details: %+v", token)
+	}
+}
+
+// records serves address records, for the tests of shared addresses.
+type records map[common.Address]pkg.AddressRecord
+
+func (r records) Lookup(addr common.Address) (pkg.AddressRecord, bool) {
+	record, ok := r[addr]
+	return record, ok
+}
+
+func TestWorker_SharedAddress(t *testing.T) {
+	genesis := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(99)})
+	block := makeChildBlock(genesis, 1e15)
+
+	labels := map[string]string{pkg.LabelWalletType: "omnibus", pkg.LabelRiskTier: "low", pkg.LabelAccountID: "acc-7"}
+	w := &Worker{config: &Config{}, userGetter: records{watched: {Owners: []string{"user-1", "user-2"}, Labels: labels}}, signer: testSigner, metrics: &Metrics{}}
+	msgs := w.processBlock(block, nil, nil)
+	if len(msgs) != 2 {
+		t.Fatalf("expected one message per owner, got %d: %+v", len(msgs), msgs)
+	}
+	for i, owner := range []string{"user-1", "user-2"} {
+		if msgs[i].UserID != owner || msgs[i].Labels[pkg.LabelAccountID] != "acc-7" || msgs[i].Labels[pkg.LabelRiskTier] != "low" {
+			t.Errorf("unexpected message for %s: %+v", owner, msgs[i])
+		}
+	}
+}
+
+func TestWorker_ProcessNFTLogs(t *testing.T) {
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(100)})
+	collection := common.HexToAddress("0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D")
+	operator := common.BytesToHash(stranger.Bytes())
+	batch, err := transferBatchArgs.Pack([]*big.Int{big.NewInt(7), big.NewInt(8)}, []*big.Int{big.NewInt(2), big.NewInt(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receipts := []*types.Receipt{{Status: types.ReceiptStatusSuccessful, Logs: []*types.Log{
+		{
+			Address: collection,
+			Topics:  []common.Hash{transferTopic, common.BytesToHash(stranger.Bytes()), common.BytesToHash(watched.Bytes()), common.BigToHash(big.NewInt(42))},
+			Index:   0,
+		},
+		{
+			Address: collection,
+			Topics:  []common.Hash{transferSingleTopic, operator, common.BytesToHash(watched.Bytes()), common.BytesToHash(stranger.Bytes())},
+			Data:    append(common.LeftPadBytes([]byte{9}, 32), common.LeftPadBytes([]byte{3}, 32)...),
+			Index:   1,
+		},
+		{
+			Address: collection,
+			Topics:  []common.Hash{transferBatchTopic, operator, common.BytesToHash(stranger.Bytes()), common.BytesToHash(watched.Bytes())},
+			Data:    batch,
+			Index:   2,
+		},
+	}}}
+
+	w := &Worker{config: &Config{}, userGetter: fakeUsers{watched: "user-1"}, signer: testSigner, metrics: &Metrics{}}
+	msgs := w.processBlock(block, receipts, nil)
+
+	expected := []struct {
+		assetType, from, tokenID, amount string
+	}{
+		{pkg.AssetERC721, stranger.Hex(), "42", "1"},
+		{pkg.AssetERC1155, watched.Hex(), "9", "3"},
+		{pkg.AssetERC1155, stranger.Hex(), "7", "2"},
+		{pkg.AssetERC1155, stranger.Hex(), "8", "5"},
+	}
+	if len(msgs) != len(expected) {
+		t.Fatalf("expected %d messages, got %d: %+v", len(expected), len(msgs), msgs)
+	}
+	for i, e := range expected {
+		m := msgs[i]
+		if m.AssetType != e.assetType || m.From != e.from || m.TokenID != e.tokenID || m.Amount != e.amount || m.Token != collection.Hex() {
+			t.Errorf("message %d: expected %+v, got %+v", i, e, m)
+		}
+	}
+}
+
+func TestWorker_RecoverAllTxTypes(t *testing.T) {
+	chainID := uint256.NewInt(1)
+	txs := []*types.Transaction{
+		// Pre-EIP-155 legacy transaction, without chain id.
+		types.MustSignNewTx(testKey, types.HomesteadSigner{}, &types.LegacyTx{To: &watched, Value: big.NewInt(1), Gas: 21000, GasPrice: big.NewInt(1)}),
+		types.MustSignNewTx(testKey, testSigner, &types.DynamicFeeTx{ChainID: big.NewInt(1), To: &watched, Value: big.NewInt(2), Gas: 21000}),
+		types.MustSignNewTx(testKey, testSigner, &types.BlobTx{ChainID: chainID, To: watched, Value: uint256.NewInt(3), Gas: 21000, BlobHashes: []common.Hash{{0x01}}}),
+		types.MustSignNewTx(testKey, testSigner, &types.SetCodeTx{ChainID: chainID, To: watched, Value: uint256.NewInt(4), Gas: 21000, AuthList: []types.SetCodeAuthorization{{}}}),
+		// Signed for another chain: the sender cannot be recovered.
+		types.MustSignNewTx(testKey, types.LatestSignerForChainID(big.NewInt(5)), &types.DynamicFeeTx{ChainID: big.NewInt(5), To: &watched, Value: big.NewInt(5), Gas: 21000}),
+	}
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(100)}).WithBody(types.Body{Transactions: txs})
+
+	sender := crypto.PubkeyToAddress(testKey.PublicKey)
+	metrics := &Metrics{}
+	w := &Worker{config: &Config{}, userGetter: fakeUsers{watched: "user-1", sender: "user-2"}, signer: testSigner, metrics: metrics}
+	msgs := w.processBlock(block, nil, nil)
+
+	// Both sides of the first four transactions, only the recipient of the last one.
+	if len(msgs) != 9 {
+		t.Fatalf("expected 9 messages, got %d: %+v", len(msgs), msgs)
+	}
+	for i, m := range msgs[:8] {
+		if m.From != sender.Hex() {
+			t.Errorf("message %d: expected sender %s, got %s", i, sender.Hex(), m.From)
+		}
+	}
+	if last := msgs[8]; last.From != "" || last.UserID != "user-1" || last.Amount != "5" {
+		t.Errorf("unexpected message for the unrecoverable transaction: %+v", last)
+	}
+	if failures := metrics.SenderRecoveryFailures.Load(); failures != 1 {
+		t.Errorf("expected 1 sender recovery failure, got %d", failures)
+	}
+}
+
+func TestWorker_ApplyReceipts(t *testing.T) {
+	genesis := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(99)})
+	block := makeChildBlock(genesis, 1e15)
+	receipts := []*types.Receipt{{
+		Status:            types.ReceiptStatusFailed,
+		TxHash:            block.Transactions()[0].Hash(),
+		GasUsed:           21000,
+		EffectiveGasPrice: big.NewInt(2e9),
+		BlobGasUsed:       131072,
+		BlobGasPrice:      big.NewInt(3),
+	}}
+
+	w := &Worker{config: &Config{}, userGetter: fakeUsers{watched: "user-1"}, signer: testSigner, metrics: &Metrics{}}
+	msgs := w.processBlock(block, receipts, nil)
+	if len(msgs) != 1 {
+		t.Fatalf("expected the reverted transfer to be flagged, got %d messages", len(msgs))
+	}
+	m := msgs[0]
+	if m.Status == nil || *m.Status != types.ReceiptStatusFailed || m.GasUsed != 21000 || m.EffectiveGasPrice != "2000000000" {
+		t.Errorf("unexpected execution outcome: %+v", m)
+	}
+	if m.Fee != "42000000393216" {
+		t.Errorf("expected fee 42000000393216, got %s", m.Fee)
+	}
+
+	w.config.SkipReverted = true
+	if msgs := w.processBlock(block, receipts, nil); len(msgs) != 0 {
+		t.Errorf("expected the reverted transfer to be skipped, got %+v", msgs)
+	}
+}
+
+func TestWorker_ProcessWithdrawals(t *testing.T) {
+	header := &types.Header{Number: big.NewInt(17_034_871), WithdrawalsHash: &types.EmptyWithdrawalsHash}
+	block := types.NewBlockWithHeader(header).WithBody(types.Body{Withdrawals: []*types.Withdrawal{
+		{Index: 1, Validator: 42, Address: watched, Amount: 32_000_000_000},
+		{Index: 2, Validator: 43, Address: stranger, Amount: 15_000},
+	}})
+
+	w := &Worker{config: &Config{}, userGetter: fakeUsers{watched: "user-1"}, signer: testSigner, metrics: &Metrics{}}
+	msgs := w.processBlock(block, nil, nil)
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d: %+v", len(msgs), msgs)
+	}
+
+	m := msgs[0]
+	if m.Type != pkg.TypeWithdrawal || m.UserID != "user-1" || m.To != watched.Hex() || m.From != "" || m.Hash != "" {
+		t.Errorf("unexpected withdrawal message: %+v", m)
+	}
+	// 32 ETH, withdrawals are credited in gwei.
+	if m.Amount != "32000000000000000000" || m.WithdrawalIndex == nil || *m.WithdrawalIndex != 1 || m.ValidatorIndex == nil || *m.ValidatorIndex != 42 {
+		t.Errorf("unexpected withdrawal details: %+v", m)
+	}
+}
+
+func TestWorker_ProcessReward(t *testing.T) {
+	builder := common.HexToAddress("0x00000000000000000000000000000000000000cc")
+	sign := func(nonce uint64, to common.Address, value int64, gasPrice int64) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTx(&types.LegacyTx{
+			Nonce: nonce, To: &to, Value: big.NewInt(value), Gas: 21000, GasPrice: big.NewInt(gasPrice),
+		}), testSigner, testKey)
+		return tx
+	}
+	txs := []*types.Transaction{
+		sign(0, stranger, 1, 3e9),
+		// A searcher paying the builder directly.
+		sign(1, builder, 5e15, 1.5e9),
+	}
+	header := &types.Header{Number: big.NewInt(20_000_000), Coinbase: builder, BaseFee: big.NewInt(1e9)}
+	block := types.NewBlockWithHeader(header).WithBody(types.Body{Transactions: txs})
+	receipts := []*types.Receipt{
+		{Status: types.ReceiptStatusSuccessful, TxHash: txs[0].Hash(), GasUsed: 21000, EffectiveGasPrice: big.NewInt(3e9)},
+		{Status: types.ReceiptStatusSuccessful, TxHash: txs[1].Hash(), GasUsed: 21000, EffectiveGasPrice: big.NewInt(1.5e9)},
+	}
+	frames := []pkg.CallFrame{{From: usdc, To: builder, Value: big.NewInt(1e15), TxHash: txs[0].Hash(), TraceAddress: []int{0}}}
+
+	w := &Worker{config: &Config{}, userGetter: fakeUsers{builder: "builder-1"}, signer: testSigner, metrics: &Metrics{}}
+	var rewards []pkg.TxMessage
+	for _, m := range w.processBlock(block, receipts, frames) {
+		if m.Type == pkg.TypeReward {
+			rewards = append(rewards, m)
+		}
+	}
+	if len(rewards) != 1 {
+		t.Fatalf("expected 1 reward, got %+v", rewards)
+	}
+
+	r := rewards[0]
+	// Tips of 2 and 0.5 gwei over 21000 gas each, and 0.005 + 0.001 ETH of payments.
+	if r.UserID != "builder-1" || r.To != builder.Hex() || r.PriorityFees != "52500000000000" || r.BuilderPayment != "6000000000000000" {
+		t.Errorf("unexpected reward: %+v", r)
+	}
+	if r.Amount != "6052500000000000" || r.Hash != "" || r.BlockNumber != 20_000_000 {
+		t.Errorf("unexpected reward details: %+v", r)
+	}
+
+	// Without receipts the gas used, hence the fees, are unknown.
+	for _, m := range w.processBlock(block, nil, frames) {
+		if m.Type == pkg.TypeReward {
+			t.Errorf("expected no reward without receipts, got %+v", m)
+		}
+	}
+}
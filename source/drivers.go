@@ -0,0 +1,7 @@
+package source
+
+// The database drivers of the SQL sources, see Parse.
+import (
+	_ "github.com/jackc/pgx/v5/stdlib" // registers "pgx"
+	_ "modernc.org/sqlite"             // registers "sqlite"
+)
@@ -0,0 +1,135 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// CSVFile reads `address,user_id` rows, with an optional header. With a header, the columns after
+// user_id are labels named by the header, e.g. `address,user_id,walletType,riskTier`.
+type CSVFile struct {
+	Path string
+}
+
+func (f CSVFile) Rows(ctx context.Context, yield func(Row)) error {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return readCSV(file, yield)
+}
+
+func (f CSVFile) Fingerprint(ctx context.Context) (string, error) {
+	return fileFingerprint(f.Path)
+}
+
+// JSONLFile reads one `{"address": ..., "userId": ...}` object per line. A shared address lists its
+// "owners" instead of a userId, and "labels" are attached to the messages of the address.
+type JSONLFile struct {
+	Path string
+}
+
+func (f JSONLFile) Rows(ctx context.Context, yield func(Row)) error {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return readJSONL(file, yield)
+}
+
+func (f JSONLFile) Fingerprint(ctx context.Context) (string, error) {
+	return fileFingerprint(f.Path)
+}
+
+// fileFingerprint is the size and modification time of a file.
+func fileFingerprint(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+var errNoColumns = errors.New("expected an address and a user id")
+
+func readCSV(r io.Reader, yield func(Row)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	// labels are the names of the label columns, from the header.
+	var labels []string
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			yield(Row{Line: parseErr.Line, Err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		line, _ := reader.FieldPos(0)
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "address") {
+			for _, column := range record[min(2, len(record)):] {
+				labels = append(labels, strings.TrimSpace(column))
+			}
+			continue
+		}
+		if len(record) != 2+len(labels) {
+			yield(Row{Line: line, Err: fmt.Errorf("%w and %d labels, got %d columns", errNoColumns, len(labels), len(record))})
+			continue
+		}
+		row := Row{Line: line, Address: record[0], Owners: []string{strings.TrimSpace(record[1])}}
+		for i, label := range labels {
+			if value := strings.TrimSpace(record[2+i]); value != "" {
+				if row.Labels == nil {
+					row.Labels = make(map[string]string, len(labels))
+				}
+				row.Labels[label] = value
+			}
+		}
+		yield(row)
+	}
+}
+
+type jsonRow struct {
+	Address string            `json:"address"`
+	UserID  string            `json:"userId"`
+	Owners  []string          `json:"owners"`
+	Labels  map[string]string `json:"labels"`
+}
+
+func readJSONL(r io.Reader, yield func(Row)) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var row jsonRow
+		if err := json.Unmarshal([]byte(text), &row); err != nil {
+			yield(Row{Line: line, Err: fmt.Errorf("malformed JSON: %w", err)})
+			continue
+		}
+		owners := row.Owners
+		if len(owners) == 0 || row.UserID != "" && !slices.Contains(owners, row.UserID) {
+			owners = append([]string{row.UserID}, owners...)
+		}
+		yield(Row{Line: line, Address: row.Address, Owners: owners, Labels: row.Labels})
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,155 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultQuery is the query of SQL sources when none is given.
+const DefaultQuery = "SELECT address, user_id FROM addresses"
+
+// SQL reads the rows of a query returning an address and a user id, e.g. from Postgres or SQLite.
+// Further columns are labels named by the column, NULL labels are left out.
+// The database driver must be registered by the program.
+type SQL struct {
+	DB    *sql.DB
+	Query string
+	// FingerprintQuery returns values which change with the rows, e.g. SELECT count(*), max(updated_at) FROM addresses.
+	// Without it, the source cannot tell whether it changed.
+	FingerprintQuery string
+}
+
+func (s SQL) Rows(ctx context.Context, yield func(Row)) error {
+	query := s.Query
+	if query == "" {
+		query = DefaultQuery
+	}
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(columns) < 2 {
+		return fmt.Errorf("%w, the query returns %d columns", errNoColumns, len(columns))
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for line := 1; rows.Next(); line++ {
+		if err := rows.Scan(dest...); err != nil {
+			yield(Row{Line: line, Err: err})
+			continue
+		}
+		row := Row{Line: line, Address: values[0].String, Owners: []string{values[1].String}}
+		for i, label := range columns[2:] {
+			if v := values[2+i]; v.Valid {
+				if row.Labels == nil {
+					row.Labels = make(map[string]string, len(columns)-2)
+				}
+				row.Labels[label] = v.String
+			}
+		}
+		yield(row)
+	}
+	return rows.Err()
+}
+
+// Fingerprint returns the values of the first row of FingerprintQuery, "" without FingerprintQuery.
+func (s SQL) Fingerprint(ctx context.Context) (string, error) {
+	if s.FingerprintQuery == "" {
+		return "", nil
+	}
+	rows, err := s.DB.QueryContext(ctx, s.FingerprintQuery)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	if !rows.Next() {
+		return "", fmt.Errorf("fingerprint query returned no row: %w", rows.Err())
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return "", err
+	}
+
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = v.String
+	}
+	return strings.Join(fields, ","), nil
+}
+
+// HTTP downloads CSV or JSONL rows, depending on the content type of the response.
+type HTTP struct {
+	URL    string
+	Client *http.Client
+}
+
+func (h HTTP) Rows(ctx context.Context, yield func(Row)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", h.URL, resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/csv" || strings.HasSuffix(req.URL.Path, ".csv") {
+		return readCSV(resp.Body, yield)
+	}
+	return readJSONL(resp.Body, yield)
+}
+
+// Fingerprint returns the ETag, or else the Last-Modified date, of the response to a HEAD request.
+func (h HTTP) Fingerprint(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, h.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HEAD %s: %s", h.URL, resp.Status)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag, nil
+	}
+	return resp.Header.Get("Last-Modified"), nil
+}
+
+func (h HTTP) client() *http.Client {
+	if h.Client == nil {
+		return http.DefaultClient
+	}
+	return h.Client
+}
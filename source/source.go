@@ -0,0 +1,174 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"deblockTest/pkg"
+)
+
+// Source yields the watched addresses and their owners, e.g. from a file, a database or an HTTP endpoint.
+type Source interface {
+	// Rows calls yield for each row, in order. A row which cannot be read has its Err set.
+	Rows(ctx context.Context, yield func(Row)) error
+}
+
+// Fingerprinter is implemented by the sources which can tell cheaply whether they changed,
+// without reading their rows.
+type Fingerprinter interface {
+	// Fingerprint returns a value which changes with the content of the source, or "" if unknown.
+	Fingerprint(ctx context.Context) (string, error)
+}
+
+// Fingerprint returns the fingerprint of s, or "" if s cannot tell whether it changed.
+func Fingerprint(ctx context.Context, s Source) (string, error) {
+	if f, ok := s.(Fingerprinter); ok {
+		return f.Fingerprint(ctx)
+	}
+	return "", nil
+}
+
+// Row is an entry of a source, before validation.
+type Row struct {
+	// Line is the line of the row in a file, or its position in a query result.
+	Line    int
+	Address string
+	Owners  []string
+	Labels  map[string]string
+	Err     error
+}
+
+// Problem is an invalid row.
+type Problem struct {
+	Line int
+	Msg  string
+}
+
+// ValidationError lists every invalid row of a source.
+type ValidationError struct {
+	Problems []Problem
+}
+
+// maxReported bounds the problems written in the error message.
+const maxReported = 20
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d invalid rows:", len(e.Problems))
+	for i, p := range e.Problems {
+		if i == maxReported {
+			fmt.Fprintf(&b, "\n  ... and %d more", len(e.Problems)-maxReported)
+			break
+		}
+		fmt.Fprintf(&b, "\n  line %d: %s", p.Line, p.Msg)
+	}
+	return b.String()
+}
+
+// Load reads a source and validates every row: malformed rows, addresses with a wrong EIP-55 checksum,
+// duplicate owners and conflicting labels are all reported, with their line, in a *ValidationError.
+// The rows of a shared address, one per owner, are merged. Nothing is returned unless the whole source is valid.
+func Load(ctx context.Context, s Source) (map[common.Address]pkg.AddressRecord, error) {
+	addresses := make(map[common.Address]pkg.AddressRecord)
+	firstLine := make(map[common.Address]int)
+	var problems []Problem
+
+	err := s.Rows(ctx, func(row Row) {
+		if row.Err != nil {
+			problems = append(problems, Problem{row.Line, row.Err.Error()})
+			return
+		}
+		addr, err := parseAddress(row.Address)
+		if err != nil {
+			problems = append(problems, Problem{row.Line, err.Error()})
+			return
+		}
+		if len(row.Owners) == 0 || slices.Contains(row.Owners, "") {
+			problems = append(problems, Problem{row.Line, fmt.Sprintf("address %s has no user", addr.Hex())})
+			return
+		}
+		line, ok := firstLine[addr]
+		if !ok {
+			firstLine[addr] = row.Line
+			addresses[addr] = pkg.AddressRecord{Owners: slices.Clone(row.Owners), Labels: row.Labels}
+			return
+		}
+
+		record := addresses[addr]
+		for _, owner := range row.Owners {
+			if slices.Contains(record.Owners, owner) {
+				problems = append(problems, Problem{row.Line, fmt.Sprintf("duplicate address %s for user %s, first seen line %d", addr.Hex(), owner, line)})
+				return
+			}
+		}
+		for k, v := range row.Labels {
+			if prev, ok := record.Labels[k]; ok && prev != v {
+				problems = append(problems, Problem{row.Line, fmt.Sprintf("conflicting %s label of address %s, %q on line %d", k, addr.Hex(), prev, line)})
+				return
+			}
+		}
+		record.Owners = append(record.Owners, row.Owners...)
+		if len(row.Labels) > 0 {
+			record.Labels = maps.Clone(record.Labels)
+			if record.Labels == nil {
+				record.Labels = make(map[string]string, len(row.Labels))
+			}
+			maps.Copy(record.Labels, row.Labels)
+		}
+		addresses[addr] = record
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return addresses, nil
+}
+
+// parseAddress accepts hex addresses in lower case, upper case, or with a valid EIP-55 checksum.
+func parseAddress(s string) (common.Address, error) {
+	s = strings.TrimSpace(s)
+	if !common.IsHexAddress(s) || !strings.HasPrefix(s, "0x") {
+		return common.Address{}, fmt.Errorf("malformed address %q", s)
+	}
+	addr := common.HexToAddress(s)
+	hex := s[2:]
+	if hex != strings.ToLower(hex) && hex != strings.ToUpper(hex) && s != addr.Hex() {
+		return common.Address{}, fmt.Errorf("bad checksum for address %s, expected %s", s, addr.Hex())
+	}
+	return addr, nil
+}
+
+// Parse returns the source described by spec: a .csv or .jsonl file, an http(s) URL,
+// a postgres:// connection string or a sqlite://path. SQL sources run query, DefaultQuery if empty.
+func Parse(spec, query string) (Source, error) {
+	switch {
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return HTTP{URL: spec}, nil
+	case strings.HasPrefix(spec, "postgres://"), strings.HasPrefix(spec, "postgresql://"):
+		return openSQL("pgx", spec, query)
+	case strings.HasPrefix(spec, "sqlite://"):
+		return openSQL("sqlite", strings.TrimPrefix(spec, "sqlite://"), query)
+	case strings.HasSuffix(spec, ".csv"):
+		return CSVFile{Path: spec}, nil
+	case strings.HasSuffix(spec, ".jsonl"), strings.HasSuffix(spec, ".ndjson"):
+		return JSONLFile{Path: spec}, nil
+	default:
+		return nil, fmt.Errorf("unknown address source %q", spec)
+	}
+}
+
+func openSQL(driver, dsn, query string) (Source, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return SQL{DB: db, Query: query}, nil
+}
@@ -0,0 +1,204 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"deblockTest/pkg"
+)
+
+const (
+	checksummed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	lower       = "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func problemLines(t *testing.T, err error) map[int]bool {
+	t.Helper()
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	lines := make(map[int]bool)
+	for _, p := range validation.Problems {
+		lines[p.Line] = true
+	}
+	return lines
+}
+
+func TestLoad_CSV(t *testing.T) {
+	valid := writeFile(t, "valid.csv", "address,user_id\n"+checksummed+",user1\n"+lower+", user2\n")
+	addresses, err := Load(context.Background(), CSVFile{Path: valid})
+	if err != nil {
+		t.Fatalf("Failed to load addresses: %v", err)
+	}
+	if len(addresses) != 2 || !slices.Equal(addresses[common.HexToAddress(checksummed)].Owners, []string{"user1"}) || !slices.Equal(addresses[common.HexToAddress(lower)].Owners, []string{"user2"}) {
+		t.Errorf("Unexpected addresses: %v", addresses)
+	}
+
+	// A shared address has a row per owner, the header names the label columns.
+	labelled := writeFile(t, "labelled.csv", strings.Join([]string{
+		"address,user_id,walletType,riskTier",
+		checksummed + ",user1,omnibus,",
+		checksummed + ",user2,omnibus,low",
+		lower + ",user3,,",
+	}, "\n"))
+	addresses, err = Load(context.Background(), CSVFile{Path: labelled})
+	if err != nil {
+		t.Fatalf("Failed to load addresses: %v", err)
+	}
+	shared := addresses[common.HexToAddress(checksummed)]
+	if !slices.Equal(shared.Owners, []string{"user1", "user2"}) || shared.Labels[pkg.LabelWalletType] != "omnibus" || shared.Labels[pkg.LabelRiskTier] != "low" {
+		t.Errorf("Unexpected shared address: %+v", shared)
+	}
+	if single := addresses[common.HexToAddress(lower)]; len(single.Owners) != 1 || single.Labels != nil {
+		t.Errorf("Unexpected address without labels: %+v", single)
+	}
+
+	invalid := writeFile(t, "invalid.csv", strings.Join([]string{
+		checksummed + ",user1",
+		"0x5aaeb6053F3E94C9b9A09f33669435E7Ef1BeAed,user2", // bad checksum
+		"0x1234,user3",                                     // too short
+		strings.ToLower(checksummed) + ",user1",            // duplicate of line 1
+		lower + ",",                                        // no user
+		lower + ",user6,extra",                             // extra column
+	}, "\n"))
+	_, err = Load(context.Background(), CSVFile{Path: invalid})
+	lines := problemLines(t, err)
+	for line := 2; line <= 6; line++ {
+		if !lines[line] {
+			t.Errorf("Expected line %d to be reported, got %v", line, err)
+		}
+	}
+	if lines[1] {
+		t.Errorf("Expected line 1 to be valid, got %v", err)
+	}
+}
+
+func TestLoad_JSONL(t *testing.T) {
+	path := writeFile(t, "addresses.jsonl", `{"address": "`+checksummed+`", "userId": "user1"}
+
+{"address": "`+lower+`", "userId": "user2"
+`)
+	_, err := Load(context.Background(), JSONLFile{Path: path})
+	if lines := problemLines(t, err); len(lines) != 1 || !lines[3] {
+		t.Errorf("Expected only line 3 to be reported, got %v", err)
+	}
+}
+
+func TestLoad_HTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		_, _ = w.Write([]byte(checksummed + ",user1\n"))
+	}))
+	defer server.Close()
+
+	addresses, err := Load(context.Background(), HTTP{URL: server.URL})
+	if err != nil || addresses[common.HexToAddress(checksummed)].Owners[0] != "user1" {
+		t.Errorf("Unexpected addresses %v (%v)", addresses, err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	ctx := context.Background()
+	path := writeFile(t, "addresses.csv", checksummed+",user1\n")
+	before, err := Fingerprint(ctx, CSVFile{Path: path})
+	if err != nil || before == "" {
+		t.Fatalf("Expected a file fingerprint, got %q (%v)", before, err)
+	}
+	if err := os.WriteFile(path, []byte(checksummed+",user1\n"+lower+",user2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := Fingerprint(ctx, CSVFile{Path: path}); after == before {
+		t.Error("Expected the fingerprint to change with the file")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+	}))
+	defer server.Close()
+	if etag, err := Fingerprint(ctx, HTTP{URL: server.URL}); err != nil || etag != `"v2"` {
+		t.Errorf("Expected the ETag as fingerprint, got %q (%v)", etag, err)
+	}
+
+	if fingerprint, err := Fingerprint(ctx, SQL{}); err != nil || fingerprint != "" {
+		t.Errorf("Expected no fingerprint for SQL sources without fingerprint query, got %q (%v)", fingerprint, err)
+	}
+
+	db := openSQLite(t, `CREATE TABLE addresses (address TEXT, user_id TEXT);
+		INSERT INTO addresses VALUES ('`+checksummed+`', 'user1');`)
+	db.FingerprintQuery = "SELECT count(*), max(user_id) FROM addresses"
+	before, err = Fingerprint(ctx, db)
+	if err != nil || before != "1,user1" {
+		t.Fatalf("Expected the values of the fingerprint query, got %q (%v)", before, err)
+	}
+	if _, err := db.DB.Exec(`INSERT INTO addresses VALUES ('` + lower + `', 'user2')`); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := Fingerprint(ctx, db); after == before {
+		t.Error("Expected the fingerprint to change with the rows")
+	}
+}
+
+// openSQLite creates a SQLite address source holding the rows inserted by statements.
+func openSQLite(t *testing.T, statements string) SQL {
+	t.Helper()
+	s, err := Parse("sqlite://"+filepath.Join(t.TempDir(), "addresses.db"), "")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite: %v", err)
+	}
+	src := s.(SQL)
+	t.Cleanup(func() { src.DB.Close() })
+	if _, err := src.DB.Exec(statements); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+func TestLoad_SQL(t *testing.T) {
+	s := openSQLite(t, `CREATE TABLE addresses (address TEXT, user_id TEXT);
+		INSERT INTO addresses VALUES ('`+checksummed+`', 'user1'), ('`+lower+`', NULL);`)
+
+	_, err := Load(context.Background(), s)
+	if lines := problemLines(t, err); len(lines) != 1 || !lines[2] {
+		t.Errorf("Expected only row 2 to be reported, got %v", err)
+	}
+}
+
+func TestParse_SQLDrivers(t *testing.T) {
+	s := openSQLite(t, `CREATE TABLE addresses (address TEXT, user_id TEXT, walletType TEXT);
+		INSERT INTO addresses VALUES ('`+checksummed+`', 'user1', 'hot'), ('`+lower+`', 'user2', NULL);`)
+
+	s.Query = "SELECT * FROM addresses"
+	addresses, err := Load(context.Background(), s)
+	if err != nil {
+		t.Fatalf("Failed to load addresses: %v", err)
+	}
+	if hot := addresses[common.HexToAddress(checksummed)]; hot.Owners[0] != "user1" || hot.Labels["walletType"] != "hot" || len(addresses) != 2 {
+		t.Errorf("Unexpected addresses: %v", addresses)
+	}
+
+	// Opening does not connect, it only needs the driver.
+	pg, err := Parse("postgres://user@localhost/db", "")
+	if err != nil {
+		t.Fatalf("Expected the Postgres driver to be linked: %v", err)
+	}
+	pg.(SQL).DB.Close()
+}
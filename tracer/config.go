@@ -0,0 +1,13 @@
+package tracer
+
+// Tracing methods, see Config.Method.
+const (
+	// MethodDebug uses debug_traceBlockByHash with the callTracer (geth, reth, nethermind).
+	MethodDebug = "debug"
+	// MethodParity uses trace_block (erigon, nethermind, most providers' trace API).
+	MethodParity = "parity"
+)
+
+type Config struct {
+	Method string
+}
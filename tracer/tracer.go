@@ -0,0 +1,187 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"deblockTest/pkg"
+)
+
+type Tracer struct {
+	config *Config
+	client *rpc.Client
+}
+
+func NewFromConfig(cfg *Config, client *rpc.Client) *Tracer {
+	return &Tracer{config: cfg, client: client}
+}
+
+// TraceBlock returns the internal calls of a block that moved ETH. Top-level calls are left out,
+// they are already covered by the transactions themselves, and so are reverted frames.
+func (t *Tracer) TraceBlock(ctx context.Context, number uint64, hash common.Hash) ([]pkg.CallFrame, error) {
+	switch t.config.Method {
+	case MethodDebug:
+		return t.debugTraceBlock(ctx, hash)
+	case MethodParity:
+		return t.parityTraceBlock(ctx, number)
+	default:
+		return nil, fmt.Errorf("unknown tracing method %q", t.config.Method)
+	}
+}
+
+type callFrame struct {
+	Type  string          `json:"type"`
+	From  common.Address  `json:"from"`
+	To    *common.Address `json:"to"`
+	Value *hexutil.Big    `json:"value"`
+	Error string          `json:"error"`
+	Calls []callFrame     `json:"calls"`
+}
+
+type txTrace struct {
+	TxHash common.Hash `json:"txHash"`
+	Result callFrame   `json:"result"`
+}
+
+func (t *Tracer) debugTraceBlock(ctx context.Context, hash common.Hash) ([]pkg.CallFrame, error) {
+	var traces []txTrace
+	err := t.client.CallContext(ctx, &traces, "debug_traceBlockByHash", hash, map[string]string{"tracer": "callTracer"})
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []pkg.CallFrame
+	for _, trace := range traces {
+		if trace.Result.Error != "" {
+			continue
+		}
+		for i, call := range trace.Result.Calls {
+			frames = collectCalls(frames, trace.TxHash, call, []int{i})
+		}
+	}
+	return frames, nil
+}
+
+func collectCalls(frames []pkg.CallFrame, txHash common.Hash, call callFrame, path []int) []pkg.CallFrame {
+	if call.Error != "" {
+		// A reverted frame moves nothing, and neither do its children.
+		return frames
+	}
+	if movesValue(call.Type) && call.To != nil && call.Value != nil && call.Value.ToInt().Sign() > 0 {
+		frames = append(frames, pkg.CallFrame{
+			TxHash:       txHash,
+			From:         call.From,
+			To:           *call.To,
+			Value:        call.Value.ToInt(),
+			TraceAddress: path,
+		})
+	}
+	for i, child := range call.Calls {
+		frames = collectCalls(frames, txHash, child, append(slices.Clip(path), i))
+	}
+	return frames
+}
+
+// movesValue tells whether a call type transfers its value to its target. DELEGATECALL reports
+// the value of its parent, STATICCALL cannot carry value and CALLCODE credits the caller itself.
+func movesValue(callType string) bool {
+	switch strings.ToUpper(callType) {
+	case "CALL", "CREATE", "CREATE2", "SELFDESTRUCT":
+		return true
+	default:
+		return false
+	}
+}
+
+type parityTrace struct {
+	Action struct {
+		CallType      string          `json:"callType"`
+		From          common.Address  `json:"from"`
+		To            *common.Address `json:"to"`
+		Value         *hexutil.Big    `json:"value"`
+		Address       common.Address  `json:"address"`
+		RefundAddress *common.Address `json:"refundAddress"`
+		Balance       *hexutil.Big    `json:"balance"`
+	} `json:"action"`
+	Result *struct {
+		Address *common.Address `json:"address"`
+	} `json:"result"`
+	TraceAddress    []int        `json:"traceAddress"`
+	TransactionHash *common.Hash `json:"transactionHash"`
+	Type            string       `json:"type"`
+	Error           string       `json:"error"`
+}
+
+func (t *Tracer) parityTraceBlock(ctx context.Context, number uint64) ([]pkg.CallFrame, error) {
+	var traces []parityTrace
+	err := t.client.CallContext(ctx, &traces, "trace_block", hexutil.EncodeUint64(number))
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []pkg.CallFrame
+	var reverted [][]int
+	var revertedTx common.Hash
+	for _, trace := range traces {
+		// Block and uncle rewards carry no transaction.
+		if trace.TransactionHash == nil {
+			continue
+		}
+		// Traces are ordered depth-first, so the reverted subtrees of a transaction come right after their root.
+		if *trace.TransactionHash != revertedTx {
+			revertedTx, reverted = *trace.TransactionHash, nil
+		}
+		if trace.Error != "" || isWithin(trace.TraceAddress, reverted) {
+			reverted = append(reverted, trace.TraceAddress)
+			continue
+		}
+		if len(trace.TraceAddress) == 0 {
+			continue
+		}
+
+		frame := pkg.CallFrame{TxHash: *trace.TransactionHash, TraceAddress: trace.TraceAddress}
+		var value *hexutil.Big
+		switch trace.Type {
+		case "call":
+			if !movesValue(trace.Action.CallType) || trace.Action.To == nil {
+				continue
+			}
+			frame.From, frame.To, value = trace.Action.From, *trace.Action.To, trace.Action.Value
+		case "create":
+			if trace.Result == nil || trace.Result.Address == nil {
+				continue
+			}
+			frame.From, frame.To, value = trace.Action.From, *trace.Result.Address, trace.Action.Value
+		case "suicide":
+			if trace.Action.RefundAddress == nil {
+				continue
+			}
+			frame.From, frame.To, value = trace.Action.Address, *trace.Action.RefundAddress, trace.Action.Balance
+		default:
+			continue
+		}
+		if value == nil || value.ToInt().Sign() <= 0 {
+			continue
+		}
+		frame.Value = new(big.Int).Set(value.ToInt())
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// isWithin tells whether traceAddress is a descendant of one of the given roots.
+func isWithin(traceAddress []int, roots [][]int) bool {
+	for _, root := range roots {
+		if len(traceAddress) >= len(root) && slices.Equal(traceAddress[:len(root)], root) {
+			return true
+		}
+	}
+	return false
+}
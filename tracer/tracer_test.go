@@ -0,0 +1,88 @@
+package tracer
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const debugTraces = `[{
+	"txHash": "0x00000000000000000000000000000000000000000000000000000000000000a1",
+	"result": {
+		"type": "CALL", "from": "0x0000000000000000000000000000000000000001", "to": "0x0000000000000000000000000000000000000002", "value": "0x5",
+		"calls": [
+			{"type": "CALL", "from": "0x0000000000000000000000000000000000000002", "to": "0x0000000000000000000000000000000000000003", "value": "0x3",
+			 "calls": [{"type": "DELEGATECALL", "from": "0x0000000000000000000000000000000000000003", "to": "0x0000000000000000000000000000000000000004", "value": "0x3"}]},
+			{"type": "CALL", "from": "0x0000000000000000000000000000000000000002", "to": "0x0000000000000000000000000000000000000005", "value": "0x2", "error": "execution reverted",
+			 "calls": [{"type": "CALL", "from": "0x0000000000000000000000000000000000000005", "to": "0x0000000000000000000000000000000000000006", "value": "0x1"}]},
+			{"type": "STATICCALL", "from": "0x0000000000000000000000000000000000000002", "to": "0x0000000000000000000000000000000000000007"}
+		]
+	}
+}]`
+
+const parityTraces = `[
+	{"type": "call", "action": {"callType": "call", "from": "0x0000000000000000000000000000000000000001", "to": "0x0000000000000000000000000000000000000002", "value": "0x5"},
+	 "traceAddress": [], "transactionHash": "0x00000000000000000000000000000000000000000000000000000000000000a1"},
+	{"type": "call", "action": {"callType": "call", "from": "0x0000000000000000000000000000000000000002", "to": "0x0000000000000000000000000000000000000003", "value": "0x3"},
+	 "traceAddress": [0], "transactionHash": "0x00000000000000000000000000000000000000000000000000000000000000a1"},
+	{"type": "call", "action": {"callType": "delegatecall", "from": "0x0000000000000000000000000000000000000003", "to": "0x0000000000000000000000000000000000000004", "value": "0x3"},
+	 "traceAddress": [0, 0], "transactionHash": "0x00000000000000000000000000000000000000000000000000000000000000a1"},
+	{"type": "call", "action": {"callType": "call", "from": "0x0000000000000000000000000000000000000002", "to": "0x0000000000000000000000000000000000000005", "value": "0x2"},
+	 "error": "Reverted", "traceAddress": [1], "transactionHash": "0x00000000000000000000000000000000000000000000000000000000000000a1"},
+	{"type": "call", "action": {"callType": "call", "from": "0x0000000000000000000000000000000000000005", "to": "0x0000000000000000000000000000000000000006", "value": "0x1"},
+	 "traceAddress": [1, 0], "transactionHash": "0x00000000000000000000000000000000000000000000000000000000000000a1"},
+	{"type": "reward", "action": {"author": "0x0000000000000000000000000000000000000008", "value": "0x1"}, "traceAddress": []}
+]`
+
+type fakeDebug struct{}
+
+func (fakeDebug) TraceBlockByHash(common.Hash, map[string]string) json.RawMessage {
+	return json.RawMessage(debugTraces)
+}
+
+type fakeTrace struct{}
+
+func (fakeTrace) Block(string) json.RawMessage {
+	return json.RawMessage(parityTraces)
+}
+
+func TestTracer_TraceBlock(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("debug", fakeDebug{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterName("trace", fakeTrace{}); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	for _, method := range []string{MethodDebug, MethodParity} {
+		frames, err := NewFromConfig(&Config{Method: method}, client).TraceBlock(context.Background(), 1, common.Hash{})
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+
+		// Only the nested value-bearing CALL survives: the top-level call, the delegate call,
+		// the reverted subtree and the static call are left out.
+		if len(frames) != 1 {
+			t.Fatalf("%s: expected 1 frame, got %d: %+v", method, len(frames), frames)
+		}
+		f := frames[0]
+		if f.From != common.HexToAddress("0x2") || f.To != common.HexToAddress("0x3") || f.Value.Cmp(big.NewInt(3)) != 0 {
+			t.Errorf("%s: unexpected frame %+v", method, f)
+		}
+		if f.TxHash != common.HexToHash("0xa1") || !slices.Equal(f.TraceAddress, []int{0}) {
+			t.Errorf("%s: unexpected frame location %+v", method, f)
+		}
+	}
+
+	if _, err := NewFromConfig(&Config{Method: "otterscan"}, client).TraceBlock(context.Background(), 1, common.Hash{}); err == nil {
+		t.Error("expected an error for an unknown tracing method")
+	}
+}